@@ -0,0 +1,357 @@
+package main
+
+// Agents run checks from inside private networks (that the scheduler in Lambda can't reach).
+// An agent fetches its due monitors over the REST API, checks them with the same scanner that
+// the scheduler uses and reports the results back. Alerting is done on our side.
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/cryptorandombytes"
+	"github.com/function61/gokit/envvar"
+	"github.com/function61/gokit/ezhttp"
+	"github.com/function61/gokit/logex"
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+var agentNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func agentEntry() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run checks from a private network, or manage agents",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "run",
+		Short: "Run the agent (needs ALERTMANAGER_BASEURL and ALERTMANAGER_AGENT_TOKEN)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := logex.StandardLogger()
+
+			exitIfError(agentRun(
+				ossignal.InterruptOrTerminateBackgroundCtx(logger),
+				logger))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls",
+		Short: "List agents",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(agentList(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil)))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "mk [name]",
+		Short: "Create an agent (prints its token)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(agentCreate(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0]))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm [name]",
+		Short: "Remove an agent",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(agentRemove(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0]))
+		},
+	})
+
+	return cmd
+}
+
+func agentList(ctx context.Context) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	view := termtables.CreateTable()
+	view.AddHeaders("Name", "Created", "Last reported", "Monitors", "Silent")
+
+	for _, agent := range app.State.Agents() {
+		lastReported := "never"
+		if !agent.LastReported.IsZero() {
+			lastReported = agent.LastReported.Format(time.RFC3339)
+		}
+
+		view.AddRow(
+			agent.Name,
+			agent.Created.Format(time.RFC3339),
+			lastReported,
+			len(amstate.MonitorsCheckedBy(agent.Name, app.State.Monitors())),
+			boolToCheckmark(len(amstate.GetSilentAgents([]amstate.Agent{agent}, now)) > 0))
+	}
+
+	fmt.Println(view.Render())
+
+	return nil
+}
+
+func agentCreate(ctx context.Context, name string) error {
+	if !agentNameRe.MatchString(name) {
+		return fmt.Errorf("agent name can only contain letters, numbers, '_', '.' and '-'; got %s", name)
+	}
+
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	token := cryptorandombytes.Base64UrlWithoutLeadingDash(24)
+
+	if err := app.Reader.TransactWrite(ctx, func() error {
+		if amstate.FindAgentWithName(name, app.State.Agents()) != nil {
+			return fmt.Errorf("agent already exists: %s", name)
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), amdomain.NewAgentCreated(
+			name,
+			agentTokenHash(token),
+			ehevent.MetaSystemUser(time.Now())))
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Agent created. Its token (not shown again):\n\n    ALERTMANAGER_AGENT_TOKEN=%s\n", token)
+
+	return nil
+}
+
+func agentRemove(ctx context.Context, name string) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return app.Reader.TransactWrite(ctx, func() error {
+		if amstate.FindAgentWithName(name, app.State.Agents()) == nil {
+			return fmt.Errorf("agent to delete not found: %s", name)
+		}
+
+		// they'd silently stop being checked
+		if monitors := amstate.MonitorsCheckedBy(name, app.State.Monitors()); len(monitors) > 0 {
+			return fmt.Errorf("agent %s still has %d monitor(s); move or remove them first", name, len(monitors))
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), amdomain.NewAgentDeleted(
+			name,
+			ehevent.MetaSystemUser(time.Now())))
+	})
+}
+
+// we only store hashes of tokens
+func agentTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// returns nil if request doesn't have a valid agent token
+func agentFromRequest(r *http.Request, agents []amstate.Agent) *amstate.Agent {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+
+	tokenHash := []byte(agentTokenHash(token))
+
+	for _, agent := range agents {
+		if subtle.ConstantTimeCompare(tokenHash, []byte(agent.TokenHash)) == 1 {
+			return &agent
+		}
+	}
+
+	return nil
+}
+
+// what the agent sends to us after each round of checks
+type agentReport struct {
+	Results  []amdomain.MonitorCheckResult `json:"results"`
+	Failures []agentReportedFailure        `json:"failures"`
+}
+
+type agentReportedFailure struct {
+	MonitorId string            `json:"monitor_id"`
+	Error     string            `json:"error"`
+	Snapshot  *responseSnapshot `json:"snapshot"`
+}
+
+func newAgentReport(failures []monitorFailure, results []amdomain.MonitorCheckResult) agentReport {
+	reported := []agentReportedFailure{}
+	for _, failure := range failures {
+		reported = append(reported, agentReportedFailure{
+			MonitorId: failure.monitor.Id,
+			Error:     failure.err.Error(),
+			Snapshot:  failure.snapshot,
+		})
+	}
+
+	return agentReport{
+		Results:  results,
+		Failures: reported,
+	}
+}
+
+// records agent's results and alerts like we'd do for our own checks. results for monitors
+// that aren't the agent's (e.g. reassigned while the agent was checking) are ignored.
+func agentReportResults(
+	ctx context.Context,
+	agent amstate.Agent,
+	report agentReport,
+	app *amstate.App,
+	now time.Time,
+) error {
+	agentsMonitors := amstate.MonitorsCheckedBy(agent.Name, app.State.Monitors())
+
+	results := []amdomain.MonitorCheckResult{}
+	for _, result := range report.Results {
+		if amstate.FindMonitorWithId(result.Id, agentsMonitors) != nil {
+			results = append(results, result)
+		}
+	}
+
+	failures := []monitorFailure{}
+	for _, failure := range report.Failures {
+		monitor := amstate.FindMonitorWithId(failure.MonitorId, agentsMonitors)
+		if monitor == nil {
+			continue
+		}
+
+		failures = append(failures, monitorFailure{
+			err:      errors.New(failure.Error),
+			monitor:  *monitor,
+			snapshot: failure.Snapshot,
+		})
+	}
+
+	return recordResultsAndAlertFailures(ctx, failures, results, agent.Name, app, now)
+}
+
+// monitors the agent should check now
+func agentDueMonitors(agent amstate.Agent, app *amstate.App, now time.Time) []amstate.Monitor {
+	return amstate.DueMonitors(
+		amstate.EnabledMonitors(amstate.MonitorsCheckedBy(agent.Name, app.State.Monitors())),
+		agent.Name,
+		now)
+}
+
+type agentClient struct {
+	baseUrl string
+	token   string
+}
+
+func agentClientFromEnv() (*agentClient, error) {
+	baseUrl, err := envvar.Required("ALERTMANAGER_BASEURL")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := envvar.Required("ALERTMANAGER_AGENT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentClient{baseUrl, token}, nil
+}
+
+func (a *agentClient) DueMonitors(ctx context.Context) ([]amstate.Monitor, error) {
+	monitors := []amstate.Monitor{}
+	_, err := ezhttp.Get(
+		ctx,
+		a.baseUrl+"/agent/monitors",
+		ezhttp.AuthBearer(a.token),
+		ezhttp.RespondsJson(&monitors, true))
+	return monitors, err
+}
+
+func (a *agentClient) Report(ctx context.Context, report agentReport) error {
+	_, err := ezhttp.Post(
+		ctx,
+		a.baseUrl+"/agent/results",
+		ezhttp.AuthBearer(a.token),
+		ezhttp.SendJson(&report))
+	return err
+}
+
+func agentRun(ctx context.Context, logger *log.Logger) error {
+	client, err := agentClientFromEnv()
+	if err != nil {
+		return err
+	}
+
+	opts, err := getScannerOptions()
+	if err != nil {
+		return err
+	}
+
+	logl := logex.Levels(logger)
+
+	scanner := newRetryScanner(newScanner(opts))
+
+	// the scheduler runs every minute, and so do we
+	everyMinute := time.NewTicker(1 * time.Minute)
+	defer everyMinute.Stop()
+
+	for {
+		// errors are probably transient (connectivity to us), and if they're not, we'll get
+		// an alert for this agent not reporting
+		if err := agentCheckAndReport(ctx, client, scanner, opts, logger); err != nil {
+			logl.Error.Println(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-everyMinute.C:
+		}
+	}
+}
+
+func agentCheckAndReport(
+	ctx context.Context,
+	client *agentClient,
+	scanner MonitorScanner,
+	opts scannerOptions,
+	logger *log.Logger,
+) error {
+	monitors, err := client.DueMonitors(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching monitors: %w", err)
+	}
+
+	// we report even if there was nothing to check, so alertmanager knows we're alive
+	failures, results := scanMonitors(ctx, monitors, scanner, opts, logger)
+
+	if err := client.Report(ctx, newAgentReport(failures, results)); err != nil {
+		return fmt.Errorf("reporting results: %w", err)
+	}
+
+	return nil
+}
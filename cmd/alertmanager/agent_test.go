@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/eventhorizon/pkg/ehreader"
+	"github.com/function61/eventhorizon/pkg/ehreader/ehreadertest"
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestAgentReportResults(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	internal := amdomain.MonitorConfig{
+		Kind:   amdomain.MonitorKindHttp,
+		Target: "http://intranet.local/",
+		Find:   "Welcome",
+		Agents: []string{"office"},
+	}
+
+	public := amdomain.MonitorConfig{
+		Kind:   amdomain.MonitorKindHttp,
+		Target: "https://example.com/",
+		Find:   "Welcome",
+	}
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(testStreamName, amdomain.NewAgentCreated("office", agentTokenHash("s3cret"), ehevent.MetaSystemUser(t0)))
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorCreated("m1", true, internal, ehevent.MetaSystemUser(t0)))
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorCreated("m2", true, public, ehevent.MetaSystemUser(t0)))
+	eventLog.AppendE(testStreamName, amdomain.NewAlertRaised("a1", "agent office", "silent", ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	requestWithToken := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/agent/monitors", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	assert.Assert(t, agentFromRequest(requestWithToken("wrong"), app.State.Agents()) == nil)
+	assert.Assert(t, agentFromRequest(httptest.NewRequest(http.MethodGet, "/agent/monitors", nil), app.State.Agents()) == nil)
+
+	agent := agentFromRequest(requestWithToken("s3cret"), app.State.Agents())
+	assert.Assert(t, agent != nil)
+
+	due := agentDueMonitors(*agent, app, t0.Add(1*time.Minute))
+	assert.Assert(t, len(due) == 1)
+	assert.EqualString(t, due[0].Id, "m1")
+
+	assert.Ok(t, agentReportResults(ctx, *agent, agentReport{
+		Results: []amdomain.MonitorCheckResult{
+			{Id: "m1", Ok: false},
+			{Id: "m2", Ok: false}, // not the agent's
+		},
+		Failures: []agentReportedFailure{
+			{MonitorId: "m1", Error: "connection refused"},
+			{MonitorId: "m2", Error: "connection refused"},
+		},
+	}, app, t0.Add(1*time.Minute)))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	alerts := app.State.ActiveAlerts()
+	assert.Assert(t, len(alerts) == 1) // agent's own alert got acked
+	assert.EqualString(t, alerts[0].Subject, "http://intranet.local/")
+	assert.EqualString(t, alerts[0].Details, "connection refused")
+
+	assert.Assert(t, app.State.Agents()[0].LastReported.Equal(t0.Add(1*time.Minute)))
+	assert.Assert(t, !amstate.FindMonitorWithId("m1", app.State.Monitors()).LatestByLocation["office"].Ok)
+	assert.Assert(t, amstate.FindMonitorWithId("m2", app.State.Monitors()).LastChecked.IsZero())
+}
+
+func TestAgentCheckAndReport(t *testing.T) {
+	var reported agentReport
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "invalid agent token", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/agent/monitors":
+			handleJsonOutput(w, []amstate.Monitor{
+				httpMonitor("http://example.com/frontpage", "Welcome to"),
+				httpMonitor("http://notfound.net/", "doesntmatter"),
+			})
+		case "/agent/results":
+			assert.Ok(t, json.NewDecoder(r.Body).Decode(&reported))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	assert.Ok(t, agentCheckAndReport(
+		context.Background(),
+		&agentClient{server.URL, "s3cret"},
+		&testScanner{},
+		defaultScannerOptions(),
+		nil))
+
+	assert.Assert(t, len(reported.Results) == 2)
+	assert.Assert(t, len(reported.Failures) == 1)
+	assert.EqualString(t, reported.Failures[0].Error, "404: http://notfound.net/")
+	assert.Assert(t, reported.Failures[0].Snapshot.StatusCode == 404)
+
+	err := agentCheckAndReport(
+		context.Background(),
+		&agentClient{server.URL, "wrong"},
+		&testScanner{},
+		defaultScannerOptions(),
+		nil)
+	assert.Assert(t, err != nil)
+}
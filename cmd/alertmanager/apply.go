@@ -0,0 +1,550 @@
+package main
+
+// Declarative configuration: desired monitors and dead man's switches are kept in a file
+// (usually in version control), and "apply" makes the state match the file.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/jsonfile"
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/gokit/sliceutil"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// YAML or JSON
+type declaredConfig struct {
+	Monitors         []declaredMonitor        `json:"monitors" yaml:"monitors"`
+	DeadMansSwitches []declaredDeadMansSwitch `json:"deadmansswitches" yaml:"deadmansswitches"`
+}
+
+// monitors are identified by their subject (kind + target), so changing a target in the
+// config file means deleting the old monitor (with --prune) and creating a new one
+type declaredMonitor struct {
+	Kind              string                     `json:"kind" yaml:"kind"` // default http
+	Target            string                     `json:"target" yaml:"target"`
+	Find              string                     `json:"find" yaml:"find"`
+	NotFind           string                     `json:"not_find" yaml:"not_find"`
+	ExpectStatus      int                        `json:"expect_status" yaml:"expect_status"`
+	ExpectUnreachable bool                       `json:"expect_unreachable" yaml:"expect_unreachable"`
+	RecordType        string                     `json:"record_type" yaml:"record_type"` // default A (dns)
+	Expect            []string                   `json:"expect" yaml:"expect"`
+	GrpcService       string                     `json:"grpc_service" yaml:"grpc_service"`
+	Tls               bool                       `json:"tls" yaml:"tls"`
+	Steps             []amdomain.HttpStep        `json:"steps" yaml:"steps"`
+	Audit             *amdomain.HttpsAuditPolicy `json:"audit" yaml:"audit"` // default policy if not given
+	ExpiryAlertDays   int                        `json:"expiry_alert_days" yaml:"expiry_alert_days"`
+	Interval          string                     `json:"interval" yaml:"interval"` // "5m"
+	Timeout           string                     `json:"timeout" yaml:"timeout"`   // "10s"
+	AlertAfter        int                        `json:"alert_after" yaml:"alert_after"`
+	RecoverAfter      int                        `json:"recover_after" yaml:"recover_after"`
+	Agents            []string                   `json:"agents" yaml:"agents"` // default: checked by the scheduler
+	Quorum            int                        `json:"quorum" yaml:"quorum"`
+	QuorumWindow      string                     `json:"quorum_window" yaml:"quorum_window"` // "10m"
+	Enabled           *bool                      `json:"enabled" yaml:"enabled"`             // default true
+}
+
+type declaredDeadMansSwitch struct {
+	Subject     string `json:"subject" yaml:"subject"`
+	Ttl         string `json:"ttl" yaml:"ttl"`                 // initial deadline (same format as in check-ins)
+	Schedule    string `json:"schedule" yaml:"schedule"`       // cron expression. deadlines then come from it
+	Grace       string `json:"grace" yaml:"grace"`             // "45m". only with schedule
+	Description string `json:"description" yaml:"description"` // shown in alerts
+	Owner       string `json:"owner" yaml:"owner"`
+	Runbook     string `json:"runbook" yaml:"runbook"` // URL
+}
+
+func (d declaredDeadMansSwitch) metadata() deadMansSwitchMetadata {
+	return deadMansSwitchMetadata{
+		Description: d.Description,
+		Owner:       d.Owner,
+		Runbook:     d.Runbook,
+	}
+}
+
+type applyPlan struct {
+	lines  []string // human readable
+	events []ehevent.Event
+}
+
+func (p *applyPlan) add(line string, events ...ehevent.Event) {
+	p.lines = append(p.lines, line)
+	p.events = append(p.events, events...)
+}
+
+// plan is made inside the transaction (so it's made again if the transaction is retried on a
+// conflict), but printed only once after the transaction has succeeded
+func transactPlan(
+	ctx context.Context,
+	app *amstate.App,
+	dryRun bool,
+	nothingToDo string,
+	out io.Writer,
+	makePlan func(now time.Time) (*applyPlan, error),
+) error {
+	var plan *applyPlan
+
+	if err := app.Reader.TransactWrite(ctx, func() error {
+		var err error
+		plan, err = makePlan(time.Now())
+		if err != nil {
+			return err
+		}
+
+		if len(plan.events) == 0 || dryRun {
+			return nil
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), plan.events...)
+	}); err != nil {
+		return err
+	}
+
+	for _, line := range plan.lines {
+		fmt.Fprintln(out, line)
+	}
+
+	switch {
+	case len(plan.events) == 0:
+		fmt.Fprintln(out, nothingToDo)
+	case dryRun:
+		fmt.Fprintln(out, "Dry run; nothing was changed")
+	}
+
+	return nil
+}
+
+func applyEntry(
+	use string,
+	short string,
+	makePlan func(conf declaredConfig, app *amstate.App, prune bool, now time.Time) (*applyPlan, error),
+) *cobra.Command {
+	dryRun := false
+	prune := false
+
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(applyConfigFile(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0],
+				dryRun,
+				prune,
+				makePlan))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", dryRun, "Only print the plan")
+	cmd.Flags().BoolVarP(&prune, "prune", "", prune, "Delete items that are not in the file")
+
+	return cmd
+}
+
+func applyConfigFile(
+	ctx context.Context,
+	path string,
+	dryRun bool,
+	prune bool,
+	makePlan func(conf declaredConfig, app *amstate.App, prune bool, now time.Time) (*applyPlan, error),
+) error {
+	conf, err := readDeclaredConfig(path)
+	if err != nil {
+		return err
+	}
+
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return transactPlan(ctx, app, dryRun, "Nothing to change", os.Stdout, func(now time.Time) (*applyPlan, error) {
+		return makePlan(conf, app, prune, now)
+	})
+}
+
+func readDeclaredConfig(path string) (declaredConfig, error) {
+	conf := declaredConfig{}
+	return conf, readConfigFile(path, &conf)
+}
+
+// JSON if file has .json extension, YAML otherwise. unknown fields are errors
+func readConfigFile(path string, dest interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = jsonfile.Unmarshal(bytes.NewReader(content), dest, true)
+	} else {
+		err = yaml.UnmarshalStrict(content, dest)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func planMonitors(
+	conf declaredConfig,
+	app *amstate.App,
+	prune bool,
+	now time.Time,
+) (*applyPlan, error) {
+	for _, decl := range conf.Monitors {
+		if err := mustHaveAgents(decl.Agents, app.State.Agents()); err != nil {
+			return nil, fmt.Errorf("%s: %w", decl.Target, err)
+		}
+	}
+
+	return planMonitorChanges(conf.Monitors, app.State.Monitors(), prune, now)
+}
+
+func planMonitorChanges(
+	declared []declaredMonitor,
+	existing []amstate.Monitor,
+	prune bool,
+	now time.Time,
+) (*applyPlan, error) {
+	if declared == nil { // guard against --prune deleting everything due to a typo in the file
+		return nil, errors.New("no monitors section in file (use empty list to declare no monitors)")
+	}
+
+	plan := &applyPlan{}
+
+	declaredSubjects := map[string]bool{}
+
+	for _, decl := range declared {
+		config, err := decl.toMonitorConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", decl.Target, err)
+		}
+
+		subject := amstate.Monitor{MonitorConfig: config}.Subject()
+		if declaredSubjects[subject] {
+			return nil, fmt.Errorf("declared more than once: %s", subject)
+		}
+		declaredSubjects[subject] = true
+
+		enabled := decl.Enabled == nil || *decl.Enabled
+
+		current := amstate.FindMonitorWithSubject(subject, existing)
+		if current == nil {
+			plan.add(
+				fmt.Sprintf("+ create %s", subject),
+				amdomain.NewMonitorCreated(
+					amstate.NewMonitorId(),
+					enabled,
+					config,
+					ehevent.MetaSystemUser(now)))
+			continue
+		}
+
+		if changed := changedMonitorConfigFields(monitorConfigWithDefaults(current.MonitorConfig), config); len(changed) > 0 {
+			plan.add(
+				fmt.Sprintf("~ update %s %s (%s)", current.Id, subject, strings.Join(changed, ", ")),
+				amdomain.NewMonitorUpdated(
+					current.Id,
+					config,
+					ehevent.MetaSystemUser(now)))
+		}
+
+		if enabled != current.Enabled {
+			verb := "disable"
+			if enabled {
+				verb = "enable"
+			}
+
+			plan.add(
+				fmt.Sprintf("~ %s %s %s", verb, current.Id, subject),
+				amdomain.NewMonitorEnabledUpdated(
+					current.Id,
+					enabled,
+					ehevent.MetaSystemUser(now)))
+		}
+	}
+
+	for _, mon := range existing {
+		if declaredSubjects[mon.Subject()] {
+			continue
+		}
+
+		if prune {
+			plan.add(
+				fmt.Sprintf("- delete %s %s", mon.Id, mon.Subject()),
+				amdomain.NewMonitorDeleted(
+					mon.Id,
+					ehevent.MetaSystemUser(now)))
+		} else {
+			plan.add(fmt.Sprintf("  not in file: %s %s (--prune would delete)", mon.Id, mon.Subject()))
+		}
+	}
+
+	return plan, nil
+}
+
+func planDeadMansSwitches(
+	conf declaredConfig,
+	app *amstate.App,
+	prune bool,
+	now time.Time,
+) (*applyPlan, error) {
+	return planDeadMansSwitchChanges(conf.DeadMansSwitches, app.State.DeadMansSwitches(), prune, now)
+}
+
+func planDeadMansSwitchChanges(
+	declared []declaredDeadMansSwitch,
+	existing []amstate.DeadMansSwitch,
+	prune bool,
+	now time.Time,
+) (*applyPlan, error) {
+	if declared == nil { // guard against --prune deleting everything due to a typo in the file
+		return nil, errors.New("no deadmansswitches section in file (use empty list to declare no switches)")
+	}
+
+	plan := &applyPlan{}
+
+	declaredSubjects := map[string]bool{}
+
+	for _, decl := range declared {
+		if decl.Subject == "" || (decl.Ttl == "" && decl.Schedule == "") {
+			return nil, fmt.Errorf("subject or ttl/schedule empty: %+v", decl)
+		}
+
+		if declaredSubjects[decl.Subject] {
+			return nil, fmt.Errorf("declared more than once: %s", decl.Subject)
+		}
+		declaredSubjects[decl.Subject] = true
+
+		grace, err := decl.scheduleGrace(now)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", decl.Subject, err)
+		}
+
+		metadata := decl.metadata()
+		if err := metadata.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", decl.Subject, err)
+		}
+
+		var ttl time.Time
+		if decl.Ttl != "" {
+			ttl, err = parseTtlSpec(decl.Ttl, now)
+		} else {
+			ttl, err = nextScheduledDeadline(decl.Schedule, grace, now)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", decl.Subject, err)
+		}
+
+		// deadline of an existing switch is moved by check-ins, not by us
+		if current := amstate.FindDeadMansSwitchWithSubject(decl.Subject, existing); current != nil {
+			if !metadata.Equal(*current) {
+				plan.add(
+					fmt.Sprintf("~ update description/owner/runbook of %s", decl.Subject),
+					metadata.toEvent(decl.Subject, now))
+			}
+
+			if current.Schedule != decl.Schedule || current.Grace != grace {
+				if decl.Schedule != "" { // new schedule => new deadline from it
+					ttl, err = nextScheduledDeadline(decl.Schedule, grace, now)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", decl.Subject, err)
+					}
+				} else {
+					ttl = current.Ttl
+				}
+
+				plan.add(
+					fmt.Sprintf("~ reschedule %s (%s => %s)", decl.Subject, scheduleOrNone(current.Schedule, current.Grace), scheduleOrNone(decl.Schedule, grace)),
+					amdomain.NewDeadMansSwitchScheduleUpdated(
+						decl.Subject,
+						decl.Schedule,
+						grace,
+						ttl,
+						ehevent.MetaSystemUser(now)))
+			}
+
+			continue
+		}
+
+		events := []ehevent.Event{amdomain.NewDeadMansSwitchCreated(
+			decl.Subject,
+			ttl,
+			ehevent.MetaSystemUser(now))}
+
+		if metadata != (deadMansSwitchMetadata{}) {
+			events = append(events, metadata.toEvent(decl.Subject, now))
+		}
+
+		if decl.Schedule != "" {
+			events = append(events, amdomain.NewDeadMansSwitchScheduleUpdated(
+				decl.Subject,
+				decl.Schedule,
+				grace,
+				ttl,
+				ehevent.MetaSystemUser(now)))
+		}
+
+		plan.add(
+			fmt.Sprintf("+ create %s (first deadline %s)", decl.Subject, ttl.Format(time.RFC3339)),
+			events...)
+	}
+
+	for _, dms := range existing {
+		if declaredSubjects[dms.Subject] {
+			continue
+		}
+
+		if prune {
+			plan.add(
+				fmt.Sprintf("- delete %s", dms.Subject),
+				amdomain.NewDeadMansSwitchDeleted(
+					dms.Subject,
+					ehevent.MetaSystemUser(now)))
+		} else {
+			plan.add(fmt.Sprintf("  not in file: %s (--prune would delete)", dms.Subject))
+		}
+	}
+
+	return plan, nil
+}
+
+func (d declaredDeadMansSwitch) scheduleGrace(now time.Time) (time.Duration, error) {
+	if d.Schedule == "" {
+		if d.Grace != "" {
+			return 0, errors.New("grace without schedule")
+		}
+
+		return 0, nil
+	}
+
+	grace := defaultScheduleGrace
+	if d.Grace != "" {
+		var err error
+		grace, err = time.ParseDuration(d.Grace)
+		if err != nil {
+			return 0, fmt.Errorf("grace: %w", err)
+		}
+	}
+
+	return grace, validateDeadMansSwitchSchedule(d.Schedule, grace, now)
+}
+
+func (d declaredMonitor) toMonitorConfig() (amdomain.MonitorConfig, error) {
+	config, err := d.toMonitorConfigWithoutValidation()
+	if err != nil {
+		return config, err
+	}
+
+	return config, validateMonitorConfig(config)
+}
+
+// defaults are filled in
+func (d declaredMonitor) toMonitorConfigWithoutValidation() (amdomain.MonitorConfig, error) {
+	config := amdomain.MonitorConfig{
+		Kind:                  amdomain.MonitorKind(d.Kind),
+		Target:                d.Target,
+		Find:                  d.Find,
+		NotFind:               d.NotFind,
+		ExpectStatus:          d.ExpectStatus,
+		ExpectUnreachable:     d.ExpectUnreachable,
+		DnsRecordType:         d.RecordType,
+		Expect:                d.Expect,
+		GrpcService:           d.GrpcService,
+		Tls:                   d.Tls,
+		Steps:                 d.Steps,
+		Audit:                 d.Audit,
+		ExpiryAlertDays:       d.ExpiryAlertDays,
+		AlertAfterFailures:    d.AlertAfter,
+		RecoverAfterSuccesses: d.RecoverAfter,
+		Agents:                d.Agents,
+		Quorum:                d.Quorum,
+	}
+
+	if config.Kind == "" {
+		config.Kind = amdomain.MonitorKindHttp
+	}
+
+	if config.Kind == amdomain.MonitorKindDns && config.DnsRecordType == "" {
+		config.DnsRecordType = "A"
+	}
+
+	var err error
+	if config.Interval, err = parseOptionalDuration(d.Interval); err != nil {
+		return config, fmt.Errorf("interval: %w", err)
+	}
+	if config.Timeout, err = parseOptionalDuration(d.Timeout); err != nil {
+		return config, fmt.Errorf("timeout: %w", err)
+	}
+	if config.QuorumWindow, err = parseOptionalDuration(d.QuorumWindow); err != nil {
+		return config, fmt.Errorf("quorum_window: %w", err)
+	}
+
+	return monitorConfigWithDefaults(config), nil
+}
+
+// returns JSON names of fields that differ
+func changedMonitorConfigFields(current amdomain.MonitorConfig, desired amdomain.MonitorConfig) []string {
+	asMap := func(config amdomain.MonitorConfig) map[string]interface{} {
+		fields := map[string]interface{}{}
+		asJson, err := json.Marshal(config)
+		if err != nil {
+			panic(err)
+		}
+		if err := json.Unmarshal(asJson, &fields); err != nil {
+			panic(err)
+		}
+		return fields
+	}
+
+	currentFields := asMap(current)
+	desiredFields := asMap(desired)
+
+	changed := []string{}
+	for _, fields := range []map[string]interface{}{currentFields, desiredFields} {
+		for key := range fields {
+			if !reflect.DeepEqual(currentFields[key], desiredFields[key]) && !sliceutil.ContainsString(changed, key) {
+				changed = append(changed, key)
+			}
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+func parseOptionalDuration(spec string) (time.Duration, error) {
+	if spec == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(spec)
+}
+
+func scheduleOrNone(schedule string, grace time.Duration) string {
+	if schedule == "" {
+		return "no schedule"
+	}
+
+	return describeSchedule(schedule, grace)
+}
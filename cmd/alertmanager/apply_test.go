@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/eventhorizon/pkg/ehreader"
+	"github.com/function61/eventhorizon/pkg/ehreader/ehreadertest"
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPlanMonitorChanges(t *testing.T) {
+	conf := declaredConfig{}
+	assert.Ok(t, yaml.UnmarshalStrict([]byte(`
+monitors:
+- target: https://example.com/
+  find: Example Domain
+  interval: 5m
+- target: https://example.net/
+  find: Example
+  enabled: false
+- kind: dns
+  target: example.com
+- target: https://example.org/
+  find: Example
+`), &conf))
+
+	existing := []amstate.Monitor{
+		{
+			Id:      "m1",
+			Enabled: true,
+			MonitorConfig: amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttp,
+				Target: "https://example.com/",
+				Find:   "Example",
+			},
+		},
+		{
+			Id:      "m2",
+			Enabled: true,
+			MonitorConfig: amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttp,
+				Target: "https://example.net/",
+				Find:   "Example",
+			},
+		},
+		{
+			Id:      "m3",
+			Enabled: true,
+			MonitorConfig: amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindTcp,
+				Target: "example.com:22",
+			},
+		},
+		{
+			Id:      "m4",
+			Enabled: true,
+			MonitorConfig: amdomain.MonitorConfig{ // explicit defaults are no change
+				Kind:               amdomain.MonitorKindHttp,
+				Target:             "https://example.org/",
+				Find:               "Example",
+				Interval:           amstate.DefaultMonitorInterval,
+				Timeout:            amstate.DefaultMonitorTimeout,
+				AlertAfterFailures: 1,
+			},
+		},
+	}
+
+	plan, err := planMonitorChanges(conf.Monitors, existing, false, t0)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, strings.Join(plan.lines, "\n"), `~ update m1 https://example.com/ (find, interval)
+~ disable m2 https://example.net/
++ create dns example.com A
+  not in file: m3 tcp example.com:22 (--prune would delete)`)
+	assert.Assert(t, len(plan.events) == 3)
+
+	plan, err = planMonitorChanges(conf.Monitors, existing, true, t0)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, plan.lines[3], "- delete m3 tcp example.com:22")
+	assert.Assert(t, len(plan.events) == 4)
+
+	// no section at all is probably a typo
+	_, err = planMonitorChanges(nil, existing, true, t0)
+	assert.EqualString(t, err.Error(), "no monitors section in file (use empty list to declare no monitors)")
+
+	_, err = planMonitorChanges([]declaredMonitor{{Target: "https://example.com/"}}, existing, false, t0)
+	assert.EqualString(t, err.Error(), "https://example.com/: http monitor needs string to find")
+}
+
+func TestPlanDeadMansSwitchChanges(t *testing.T) {
+	existing := []amstate.DeadMansSwitch{
+		{Subject: "backup", Ttl: t0},
+		{Subject: "old job", Ttl: t0},
+	}
+
+	plan, err := planDeadMansSwitchChanges([]declaredDeadMansSwitch{
+		{Subject: "backup", Ttl: "+24h"},
+		{Subject: "report", Ttl: "+1h"},
+	}, existing, true, t0)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, strings.Join(plan.lines, "\n"), `+ create report (first deadline 2019-09-07T13:00:00Z)
+- delete old job`)
+	assert.Assert(t, len(plan.events) == 2)
+
+	_, err = planDeadMansSwitchChanges([]declaredDeadMansSwitch{
+		{Subject: "backup", Ttl: "+24h"},
+		{Subject: "backup", Ttl: "+1h"},
+	}, existing, true, t0.Add(time.Hour))
+	assert.EqualString(t, err.Error(), "declared more than once: backup")
+
+	existing = []amstate.DeadMansSwitch{
+		{Subject: "backup", Ttl: t0, Schedule: "0 3 * * *", Grace: 45 * time.Minute},
+		{Subject: "report", Ttl: t0},
+	}
+
+	plan, err = planDeadMansSwitchChanges([]declaredDeadMansSwitch{
+		{Subject: "backup", Schedule: "0 3 * * *", Grace: "45m"},
+		{Subject: "report", Schedule: "0 8 * * 1"},
+		{Subject: "vacuum", Schedule: "0 0 1 * *", Grace: "2h"},
+	}, existing, false, t0)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, strings.Join(plan.lines, "\n"), `~ reschedule report (no schedule => 0 8 * * 1 +15m0s)
++ create vacuum (first deadline 2019-10-01T02:00:00Z)`)
+	assert.Assert(t, len(plan.events) == 3)
+
+	_, err = planDeadMansSwitchChanges([]declaredDeadMansSwitch{
+		{Subject: "backup", Ttl: "+24h", Grace: "45m"},
+	}, existing, false, t0)
+	assert.EqualString(t, err.Error(), "backup: grace without schedule")
+
+	plan, err = planDeadMansSwitchChanges([]declaredDeadMansSwitch{
+		{Subject: "backup", Schedule: "0 3 * * *", Grace: "45m", Owner: "ops@example.com"},
+		{Subject: "report", Ttl: "+1h"},
+		{Subject: "vacuum", Ttl: "+1h", Description: "Vacuums the database", Runbook: "https://example.com/runbooks/vacuum"},
+	}, existing, false, t0)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, strings.Join(plan.lines, "\n"), `~ update description/owner/runbook of backup
++ create vacuum (first deadline 2019-09-07T13:00:00Z)`)
+	assert.Assert(t, len(plan.events) == 3)
+
+	_, err = planDeadMansSwitchChanges([]declaredDeadMansSwitch{
+		{Subject: "report", Ttl: "+1h", Runbook: "wiki/report"},
+	}, existing, false, t0)
+	assert.EqualString(t, err.Error(), "report: runbook must be http(s):// URL; got wiki/report")
+}
+
+func TestTransactPlanPrintsOnceOnRetry(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewUnnoticedAlertsNotified(
+			[]string{"dummyid"},
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	declared := []declaredDeadMansSwitch{
+		{Subject: "backup", Ttl: "+24h"},
+		{Subject: "report", Ttl: "+1h"},
+	}
+
+	plans := 0
+	out := &strings.Builder{}
+
+	assert.Ok(t, transactPlan(ctx, app, false, "Nothing to change", out, func(now time.Time) (*applyPlan, error) {
+		plans++
+		if plans == 1 { // someone else creates a switch meanwhile => our write conflicts
+			eventLog.AppendE(
+				testStreamName,
+				amdomain.NewDeadMansSwitchCreated(
+					"report",
+					t0.Add(time.Hour),
+					ehevent.MetaSystemUser(t0)))
+		}
+
+		return planDeadMansSwitchChanges(declared, app.State.DeadMansSwitches(), false, t0)
+	}))
+
+	assert.Assert(t, plans == 2)
+	assert.EqualString(t, out.String(), "+ create backup (first deadline 2019-09-08T12:00:00Z)\n")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/function61/gokit/aws/lambdautils"
+)
+
+var errBlobNotFound = errors.New("blob not found")
+
+// storage for things that are too big to be kept in our event-sourced state
+type blobStore interface {
+	Put(ctx context.Context, key string, content []byte) error
+	Get(ctx context.Context, key string) ([]byte, error) // errBlobNotFound if not found
+}
+
+// S3 if $SNAPSHOT_BUCKET is set. otherwise local directory ($SNAPSHOT_DIR or "snapshots"),
+// except in Lambda (where local files would vanish) we return nil, i.e. not configured.
+func blobStoreFromEnv() (blobStore, error) {
+	if bucket := os.Getenv("SNAPSHOT_BUCKET"); bucket != "" {
+		return newS3BlobStore(bucket)
+	}
+
+	if lambdautils.InLambda() {
+		return nil, nil
+	}
+
+	dir := os.Getenv("SNAPSHOT_DIR")
+	if dir == "" {
+		dir = "snapshots"
+	}
+
+	return newLocalDirBlobStore(dir), nil
+}
+
+type localDirBlobStore struct {
+	dir string
+}
+
+func newLocalDirBlobStore(dir string) *localDirBlobStore {
+	return &localDirBlobStore{dir}
+}
+
+func (l *localDirBlobStore) Put(_ context.Context, key string, content []byte) error {
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+func (l *localDirBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if err != nil && os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	}
+
+	return content, err
+}
+
+type s3BlobStore struct {
+	s3     *s3.S3
+	bucket string
+}
+
+func newS3BlobStore(bucket string) (*s3BlobStore, error) {
+	awsSession, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3BlobStore{
+		s3:     s3.New(awsSession),
+		bucket: bucket,
+	}, nil
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, content []byte) error {
+	_, err := s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	})
+	return err
+}
+
+func (s *s3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	res, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errBlobNotFound
+		}
+
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
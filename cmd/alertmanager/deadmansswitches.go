@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/function61/eventhorizon/pkg/ehevent"
 	"github.com/function61/gokit/ossignal"
+	"github.com/function61/gokit/stringutils"
 	"github.com/function61/lambda-alertmanager/pkg/amdomain"
 	"github.com/function61/lambda-alertmanager/pkg/amstate"
 	"github.com/scylladb/termtables"
@@ -36,6 +42,43 @@ func deadMansSwitchEntry() *cobra.Command {
 
 	cmd.AddCommand(ls)
 
+	firstDeadline := ""
+	metadata := deadMansSwitchMetadata{}
+
+	mk := &cobra.Command{
+		Use:   "mk [subject]",
+		Short: "Create a switch before its first check-in",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			if firstDeadline == "" {
+				exitIfError(errors.New("--first-deadline required"))
+			}
+
+			deadline, err := parseTtlSpec(firstDeadline, time.Now())
+			exitIfError(err)
+
+			app, err := getApp(ctx)
+			exitIfError(err)
+
+			exitIfError(deadmansswitchCreate(
+				ctx,
+				args[0],
+				deadline,
+				metadata,
+				app,
+				time.Now()))
+		},
+	}
+
+	mk.Flags().StringVarP(&firstDeadline, "first-deadline", "", firstDeadline, "Deadline of first check-in (same format as ttl, e.g. +1d@06:00)")
+	mk.Flags().StringVarP(&metadata.Description, "description", "", metadata.Description, "What the job does (shown in alerts)")
+	mk.Flags().StringVarP(&metadata.Owner, "owner", "", metadata.Owner, "Who to contact about the job (shown in alerts)")
+	mk.Flags().StringVarP(&metadata.Runbook, "runbook", "", metadata.Runbook, "URL of instructions for when the job fails (shown in alerts)")
+
+	cmd.AddCommand(mk)
+
 	cmd.AddCommand(&cobra.Command{
 		Use:   "rm [id]",
 		Short: "Remove a switch",
@@ -47,29 +90,195 @@ func deadMansSwitchEntry() *cobra.Command {
 		},
 	})
 
-	cmd.AddCommand(&cobra.Command{
+	pauseUntil := ""
+	pauseTtl := ""
+
+	pause := &cobra.Command{
+		Use:   "pause [subject]",
+		Short: "Temporarily stop alerting for a switch",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			var until *time.Time
+			if pauseUntil != "" {
+				untilTs, err := parseTtlSpec(pauseUntil, time.Now())
+				exitIfError(err)
+				until = &untilTs
+			}
+
+			app, err := getApp(ctx)
+			exitIfError(err)
+
+			exitIfError(deadmansswitchPauseOrResume(
+				ctx,
+				args[0],
+				true,
+				until,
+				pauseTtl,
+				app,
+				time.Now()))
+		},
+	}
+
+	pause.Flags().StringVarP(&pauseUntil, "until", "u", pauseUntil, "Resume automatically at (same format as ttl, e.g. +14d@09:00)")
+	pause.Flags().StringVarP(&pauseTtl, "ttl", "", pauseTtl, "Deadline after auto-resume, relative to --until (default: from schedule)")
+
+	cmd.AddCommand(pause)
+
+	resumeTtl := ""
+
+	resume := &cobra.Command{
+		Use:   "resume [subject]",
+		Short: "Resume paused switch",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			app, err := getApp(ctx)
+			exitIfError(err)
+
+			exitIfError(deadmansswitchPauseOrResume(
+				ctx,
+				args[0],
+				false,
+				nil,
+				resumeTtl,
+				app,
+				time.Now()))
+		},
+	}
+
+	resume.Flags().StringVarP(&resumeTtl, "ttl", "", resumeTtl, "New deadline (default: from schedule)")
+
+	cmd.AddCommand(resume)
+
+	message := ""
+	fieldsRaw := []string{}
+	failed := false
+
+	checkin := &cobra.Command{
 		Use:   "checkin [subject] [ttl]",
-		Short: "Make a checkin",
-		Args:  cobra.ExactArgs(2),
+		Short: "Make a checkin (ttl not needed if switch has a schedule)",
+		Args:  cobra.RangeArgs(1, 2),
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
 
-			ttl, err := parseTtlSpec(args[1], time.Now())
+			ttl := time.Time{} // from schedule
+			if len(args) > 1 {
+				var err error
+				ttl, err = parseTtlSpec(args[1], time.Now())
+				exitIfError(err)
+			}
+
+			fields, err := parseCheckinFields(fieldsRaw)
 			exitIfError(err)
 
+			exitIfError(validateCheckinPayload(message, fields))
+
 			app, err := getApp(ctx)
 			exitIfError(err)
 
+			if failed {
+				if len(fields) > 0 {
+					exitIfError(errFieldsWithFailure)
+				}
+
+				_, err = deadmansswitchReportFailure(
+					ctx,
+					args[0],
+					ttl,
+					message,
+					app,
+					time.Now())
+				exitIfError(err)
+				return
+			}
+
 			_, err = deadmansswitchCheckin(
 				ctx,
 				args[0],
 				ttl,
+				message,
+				fields,
 				app,
 				time.Now())
 			exitIfError(err)
 		},
+	}
+
+	checkin.Flags().StringVarP(&message, "message", "m", message, "Message to store with the check-in (e.g. \"backed up 42 GB\")")
+	checkin.Flags().StringArrayVarP(&fieldsRaw, "field", "f", nil, "Numeric field to store with the check-in (name=value)")
+	checkin.Flags().BoolVarP(&failed, "fail", "", failed, "Report failure (alerts now, --message has the error)")
+
+	cmd.AddCommand(checkin)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "start [subject] [maxRuntime]",
+		Short: "Mark job as started (next checkin completes the run), e.g. \"backup 2h\"",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			maxRuntime, err := time.ParseDuration(args[1])
+			exitIfError(err)
+
+			app, err := getApp(ctx)
+			exitIfError(err)
+
+			exitIfError(deadmansswitchStart(
+				ctx,
+				args[0],
+				maxRuntime,
+				app,
+				time.Now()))
+		},
 	})
 
+	grace := defaultScheduleGrace
+	removeSchedule := false
+
+	schedule := &cobra.Command{
+		Use:   "schedule [subject] [cron]",
+		Short: "Set schedule of a switch (creates it if needed), e.g. \"0 3 * * *\"",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			cronExpr := ""
+			switch {
+			case removeSchedule && len(args) == 1:
+			case !removeSchedule && len(args) == 2:
+				cronExpr = args[1]
+			default:
+				exitIfError(errors.New("give either cron expression or --remove"))
+			}
+
+			app, err := getApp(ctx)
+			exitIfError(err)
+
+			exitIfError(deadmansswitchSchedule(
+				ctx,
+				args[0],
+				cronExpr,
+				grace,
+				app,
+				time.Now()))
+		},
+	}
+
+	schedule.Flags().DurationVarP(&grace, "grace", "g", grace, "How late after scheduled time the check-in can come")
+	schedule.Flags().BoolVarP(&removeSchedule, "remove", "", removeSchedule, "Remove schedule (check-ins then need ttl)")
+
+	cmd.AddCommand(schedule)
+
+	cmd.AddCommand(deadmansswitchRunEntry())
+
+	cmd.AddCommand(applyEntry(
+		"apply [file]",
+		"Make switches match the ones declared in a YAML or JSON file",
+		planDeadMansSwitches))
+
 	return cmd
 }
 
@@ -85,10 +294,23 @@ func deadmansswitchList(ctx context.Context, expired bool) error {
 	}
 
 	view := termtables.CreateTable()
-	view.AddHeaders("Subject", "TTL")
+	now := time.Now()
+
+	view.AddHeaders("Subject", "Owner", "TTL", "Paused", "Schedule", "Running", "Last check-in")
 
 	for _, dms := range dmss {
-		view.AddRow(dms.Subject, dms.Ttl.Format(time.RFC3339))
+		lastCheckin := ""
+		if len(dms.History) > 0 {
+			last := dms.History[len(dms.History)-1]
+			lastCheckin = strings.TrimSpace(last.Time.Format(time.RFC3339) + " " + stringutils.Truncate(describeCheckin(last), 40))
+		}
+
+		running := ""
+		if dms.Run != nil {
+			running = fmt.Sprintf("since %s (max %s)", dms.Run.Started.Format(time.RFC3339), dms.Run.MaxRuntime)
+		}
+
+		view.AddRow(dms.Subject, dms.Owner, dms.Ttl.Format(time.RFC3339), describePause(dms, now), describeSchedule(dms.Schedule, dms.Grace), running, lastCheckin)
 	}
 
 	fmt.Println(view.Render())
@@ -96,6 +318,181 @@ func deadmansswitchList(ctx context.Context, expired bool) error {
 	return nil
 }
 
+// descriptive fields, shown in alerts
+type deadMansSwitchMetadata struct {
+	Description string
+	Owner       string
+	Runbook     string // URL
+}
+
+func (d deadMansSwitchMetadata) Validate() error {
+	if d.Runbook != "" && !strings.HasPrefix(d.Runbook, "https://") && !strings.HasPrefix(d.Runbook, "http://") {
+		return fmt.Errorf("runbook must be http(s):// URL; got %s", d.Runbook)
+	}
+
+	for _, field := range []string{d.Description, d.Owner, d.Runbook} {
+		if len(field) > maxCheckinMessageLength {
+			return fmt.Errorf("metadata field too long (%d > %d)", len(field), maxCheckinMessageLength)
+		}
+	}
+
+	return nil
+}
+
+func (d deadMansSwitchMetadata) Equal(dms amstate.DeadMansSwitch) bool {
+	return d.Description == dms.Description && d.Owner == dms.Owner && d.Runbook == dms.Runbook
+}
+
+var errDeadMansSwitchExists = errors.New("switch already exists")
+
+// registers a switch before its first check-in
+func deadmansswitchCreate(
+	ctx context.Context,
+	subject string,
+	firstDeadline time.Time,
+	metadata deadMansSwitchMetadata,
+	app *amstate.App,
+	now time.Time,
+) error {
+	if err := validateDeadMansSwitchCreate(subject, firstDeadline, metadata, now); err != nil {
+		return err
+	}
+
+	return app.Reader.TransactWrite(ctx, func() error {
+		if amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches()) != nil {
+			return errDeadMansSwitchExists
+		}
+
+		events := []ehevent.Event{amdomain.NewDeadMansSwitchCreated(
+			subject,
+			firstDeadline,
+			ehevent.MetaSystemUser(now))}
+
+		if metadata != (deadMansSwitchMetadata{}) {
+			events = append(events, metadata.toEvent(subject, now))
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), events...)
+	})
+}
+
+func validateDeadMansSwitchCreate(
+	subject string,
+	firstDeadline time.Time,
+	metadata deadMansSwitchMetadata,
+	now time.Time,
+) error {
+	if subject == "" {
+		return errors.New("subject empty")
+	}
+
+	if !firstDeadline.After(now) {
+		return fmt.Errorf("first deadline is in the past: %s", firstDeadline.Format(time.RFC3339))
+	}
+
+	return metadata.Validate()
+}
+
+func (d deadMansSwitchMetadata) toEvent(subject string, now time.Time) *amdomain.DeadMansSwitchMetadataUpdated {
+	return amdomain.NewDeadMansSwitchMetadataUpdated(
+		subject,
+		d.Description,
+		d.Owner,
+		d.Runbook,
+		ehevent.MetaSystemUser(now))
+}
+
+func deadmansswitchPauseOrResume(
+	ctx context.Context,
+	subject string,
+	pause bool,
+	until *time.Time, // only for pause. nil = until resumed
+	ttlSpec string, // deadline after resuming. relative to resume time
+	app *amstate.App,
+	now time.Time,
+) error {
+	if until != nil && !until.After(now) {
+		return fmt.Errorf("resume time is in the past: %s", until.Format(time.RFC3339))
+	}
+
+	return app.Reader.TransactWrite(ctx, func() error {
+		dms := amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches())
+		if dms == nil {
+			return fmt.Errorf("switch not found: %s", subject)
+		}
+
+		if !pause {
+			if !dms.IsPaused(now) {
+				return fmt.Errorf("switch left unchanged: %s", subject)
+			}
+
+			ttl, err := deadlineAfterResume(*dms, ttlSpec, now)
+			if err != nil {
+				return err
+			}
+
+			return app.AppendAfter(ctx, app.State.Version(), amdomain.NewDeadMansSwitchResumed(
+				subject,
+				ttl,
+				ehevent.MetaSystemUser(now)))
+		}
+
+		// without auto-resume the deadline is decided on resume
+		ttl := time.Time{}
+		if until != nil {
+			var err error
+			ttl, err = deadlineAfterResume(*dms, ttlSpec, *until)
+			if err != nil {
+				return err
+			}
+		} else if ttlSpec != "" {
+			return errors.New("ttl only makes sense with auto-resume time")
+		}
+
+		// pausing a paused switch is ok, since it changes the resume time
+		return app.AppendAfter(ctx, app.State.Version(), amdomain.NewDeadMansSwitchPaused(
+			subject,
+			until,
+			ttl,
+			ehevent.MetaSystemUser(now)))
+	})
+}
+
+// deadline has usually passed during the pause, and we don't want to alert right on resume
+func deadlineAfterResume(dms amstate.DeadMansSwitch, ttlSpec string, resumeAt time.Time) (time.Time, error) {
+	switch {
+	case ttlSpec != "":
+		ttl, err := parseTtlSpec(ttlSpec, resumeAt)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if !ttl.After(resumeAt) {
+			return time.Time{}, fmt.Errorf("deadline is not after resume: %s", ttl.Format(time.RFC3339))
+		}
+
+		return ttl, nil
+	case dms.Schedule != "":
+		return nextScheduledDeadline(dms.Schedule, dms.Grace, resumeAt)
+	case dms.Ttl.After(resumeAt):
+		return dms.Ttl, nil
+	default:
+		return time.Time{}, fmt.Errorf("deadline %s is not after resume; give ttl", dms.Ttl.Format(time.RFC3339))
+	}
+}
+
+// "", "until 2020-03-01T09:00:00Z" or "until resumed"
+func describePause(dms amstate.DeadMansSwitch, now time.Time) string {
+	switch {
+	case !dms.IsPaused(now):
+		return ""
+	case dms.PausedUntil != nil:
+		return "until " + dms.PausedUntil.Format(time.RFC3339)
+	default:
+		return "until resumed"
+	}
+}
+
 func deadmansswitchRemove(ctx context.Context, subject string) error {
 	app, err := getApp(ctx)
 	if err != nil {
@@ -117,28 +514,47 @@ func deadmansswitchCheckin(
 	ctx context.Context,
 	subject string,
 	ttl time.Time,
+	message string,
+	fields map[string]float64,
 	app *amstate.App,
 	now time.Time,
 ) (bool, error) {
 	alertAcked := false
 
-	checkin := amdomain.NewDeadMansSwitchCheckin(
-		subject,
-		ttl,
-		ehevent.MetaSystemUser(now))
-
 	if err := app.Reader.TransactWrite(ctx, func() error {
 		events := []ehevent.Event{}
 
+		existing := amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches())
+
+		ttl := ttl // don't mutate the argument, since the transaction can be retried
+
+		// schedule knows better than the job when it runs next
+		if existing != nil && existing.Schedule != "" {
+			var err error
+			ttl, err = nextScheduledDeadline(existing.Schedule, existing.Grace, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		if ttl.IsZero() {
+			return errTtlRequired
+		}
+
 		// first time seeing this checkin => create said switch
-		if amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches()) == nil {
+		if existing == nil {
 			events = append(events, amdomain.NewDeadMansSwitchCreated(
 				subject,
 				ttl,
 				ehevent.MetaSystemUser(now)))
 		}
 
-		events = append(events, checkin)
+		events = append(events, amdomain.NewDeadMansSwitchCheckin(
+			subject,
+			ttl,
+			message,
+			fields,
+			ehevent.MetaSystemUser(now)))
 
 		if alert := amstate.FindAlertWithSubject(subject, app.State.ActiveAlerts()); alert != nil {
 			events = append(events, amdomain.NewAlertAcknowledged(
@@ -155,3 +571,174 @@ func deadmansswitchCheckin(
 
 	return alertAcked, nil
 }
+
+var errFieldsWithFailure = errors.New("fields not supported when reporting failure")
+
+// raises an alert right away. deadline is left as is, so a later successful check-in acks the
+// alert. ttl is only used if the switch doesn't exist yet. returns true if alert was raised
+// (false if deduplicated or rate limited).
+func deadmansswitchReportFailure(
+	ctx context.Context,
+	subject string,
+	ttl time.Time,
+	message string,
+	app *amstate.App,
+	now time.Time,
+) (bool, error) {
+	var alert amstate.Alert
+
+	if err := app.Reader.TransactWrite(ctx, func() error {
+		events := []ehevent.Event{}
+
+		existing := amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches())
+		if existing == nil {
+			if ttl.IsZero() {
+				return errTtlRequired
+			}
+
+			events = append(events, amdomain.NewDeadMansSwitchCreated(
+				subject,
+				ttl,
+				ehevent.MetaSystemUser(now)))
+
+			existing = &amstate.DeadMansSwitch{Subject: subject, Ttl: ttl}
+		}
+
+		events = append(events, amdomain.NewDeadMansSwitchFailureReported(
+			subject,
+			message,
+			ehevent.MetaSystemUser(now)))
+
+		// alert's recent check-ins include this failure
+		alert = failureReportToAlert(existing.WithFailureReported(message, now), message, now)
+
+		return app.AppendAfter(ctx, app.State.Version(), events...)
+	}); err != nil {
+		return false, err
+	}
+
+	return ingestAlertsAndReturnCreatedFlag(ctx, []amstate.Alert{alert}, app)
+}
+
+// creates switch if it doesn't exist (with deadline at max runtime)
+func deadmansswitchStart(
+	ctx context.Context,
+	subject string,
+	maxRuntime time.Duration,
+	app *amstate.App,
+	now time.Time,
+) error {
+	if maxRuntime <= 0 {
+		return errMaxRuntimeNotPositive
+	}
+
+	return app.Reader.TransactWrite(ctx, func() error {
+		events := []ehevent.Event{}
+
+		if amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches()) == nil {
+			events = append(events, amdomain.NewDeadMansSwitchCreated(
+				subject,
+				now.Add(maxRuntime),
+				ehevent.MetaSystemUser(now)))
+		}
+
+		events = append(events, amdomain.NewDeadMansSwitchRunStarted(
+			subject,
+			maxRuntime,
+			ehevent.MetaSystemUser(now)))
+
+		return app.AppendAfter(ctx, app.State.Version(), events...)
+	})
+}
+
+var errMaxRuntimeNotPositive = errors.New("max runtime must be positive")
+
+const (
+	maxCheckinMessageLength = 1024
+	maxCheckinFields        = 20
+)
+
+func validateCheckinPayload(message string, fields map[string]float64) error {
+	if len(message) > maxCheckinMessageLength {
+		return fmt.Errorf("message too long (%d > %d)", len(message), maxCheckinMessageLength)
+	}
+
+	if len(fields) > maxCheckinFields {
+		return fmt.Errorf("too many fields (%d > %d)", len(fields), maxCheckinFields)
+	}
+
+	for name, value := range fields {
+		if name == "" {
+			return errors.New("field name cannot be empty")
+		}
+
+		// not representable in JSON
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("field %s: value must be a finite number", name)
+		}
+	}
+
+	return nil
+}
+
+// ["size_gb=42", "duration_min=17"] => {"size_gb": 42, "duration_min": 17}
+func parseCheckinFields(raw []string) (map[string]float64, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	fields := map[string]float64{}
+
+	for _, field := range raw {
+		pos := strings.Index(field, "=")
+		if pos == -1 {
+			return nil, fmt.Errorf("field not in name=value format: %s", field)
+		}
+
+		value, err := strconv.ParseFloat(field[pos+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: value must be a number", field[:pos])
+		}
+
+		fields[field[:pos]] = value
+	}
+
+	return fields, nil
+}
+
+// "backed up (duration_min=17, size_gb=42) [ran 17m0s]"
+func describeCheckin(checkin amstate.DeadMansSwitchCheckin) string {
+	description := describeCheckinPayload(checkin)
+
+	if checkin.Failed {
+		description = strings.TrimSpace("FAILED " + description)
+	}
+
+	if checkin.Duration > 0 {
+		description = strings.TrimSpace(fmt.Sprintf("%s [ran %s]", description, checkin.Duration))
+	}
+
+	return description
+}
+
+func describeCheckinPayload(checkin amstate.DeadMansSwitchCheckin) string {
+	names := []string{}
+	for name := range checkin.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := []string{}
+	for _, name := range names {
+		fields = append(fields, name+"="+strconv.FormatFloat(checkin.Fields[name], 'f', -1, 64))
+	}
+
+	switch {
+	case len(fields) == 0:
+		return checkin.Message
+	case checkin.Message == "":
+		return "(" + strings.Join(fields, ", ") + ")"
+	default:
+		return checkin.Message + " (" + strings.Join(fields, ", ") + ")"
+	}
+}
@@ -42,6 +42,8 @@ func TestDeadmansswitchCheckin(t *testing.T) {
 		ctx,
 		"My test switch",
 		t0.Add(1*time.Hour),
+		"",
+		nil,
 		app,
 		t0)
 	assert.Ok(t, err)
@@ -60,6 +62,8 @@ func TestDeadmansswitchCheckin(t *testing.T) {
 		ctx,
 		"My test switch",
 		t0.Add(90*time.Minute),
+		"backed up 42 GB in 17m",
+		map[string]float64{"size_gb": 42, "duration_min": 17},
 		app,
 		t0.Add(30*time.Minute))
 	assert.Ok(t, err)
@@ -69,7 +73,258 @@ func TestDeadmansswitchCheckin(t *testing.T) {
 2019-09-07T12:00:00.000Z UnnoticedAlertsNotified    {"AlertIds":["dummyid"]}
 2019-09-07T12:00:00.000Z DeadMansSwitchCreated    {"Subject":"My test switch","Ttl":"2019-09-07T13:00:00Z"}
 2019-09-07T12:00:00.000Z DeadMansSwitchCheckin    {"Subject":"My test switch","Ttl":"2019-09-07T13:00:00Z"}
-2019-09-07T12:30:00.000Z DeadMansSwitchCheckin    {"Subject":"My test switch","Ttl":"2019-09-07T13:30:00Z"}`)
+2019-09-07T12:30:00.000Z DeadMansSwitchCheckin    {"Subject":"My test switch","Ttl":"2019-09-07T13:30:00Z","Message":"backed up 42 GB in 17m","Fields":{"duration_min":17,"size_gb":42}}`)
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	dms := amstate.FindDeadMansSwitchWithSubject("My test switch", app.State.DeadMansSwitches())
+
+	assert.EqualString(t, deadMansSwitchToAlert(*dms, t0.Add(2*time.Hour)).Details, `Check-in late by 30m0s (2019-09-07T13:30:00Z)
+
+Recent check-ins:
+- 2019-09-07T12:30:00Z backed up 42 GB in 17m (duration_min=17, size_gb=42)
+- 2019-09-07T12:00:00Z`)
+}
+
+func TestDeadmansswitchStart(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewUnnoticedAlertsNotified(
+			[]string{"dummyid"},
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	assert.Assert(t, deadmansswitchStart(ctx, "backup", 0, app, t0) == errMaxRuntimeNotPositive)
+
+	assert.Ok(t, deadmansswitchStart(ctx, "backup", 2*time.Hour, app, t0))
+
+	assert.EqualString(t, newEventDumper(testStreamName, eventLog, amdomain.Types).Dump(), `
+2019-09-07T12:00:00.000Z UnnoticedAlertsNotified    {"AlertIds":["dummyid"]}
+2019-09-07T12:00:00.000Z DeadMansSwitchCreated    {"Subject":"backup","Ttl":"2019-09-07T14:00:00Z"}
+2019-09-07T12:00:00.000Z DeadMansSwitchRunStarted    {"Subject":"backup","MaxRuntime":7200000000000}`)
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	dms := amstate.FindDeadMansSwitchWithSubject("backup", app.State.DeadMansSwitches())
+
+	assert.EqualString(t, overdueRunToAlert(*dms, t0.Add(2*time.Hour)).Details, "Job backup started at 2019-09-07T12:00:00Z but did not finish within 2h0m0s")
+
+	// completes the run
+	_, err = deadmansswitchCheckin(ctx, "backup", t0.Add(26*time.Hour), "", nil, app, t0.Add(17*time.Minute))
+	assert.Ok(t, err)
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	dms = amstate.FindDeadMansSwitchWithSubject("backup", app.State.DeadMansSwitches())
+	assert.Assert(t, dms.Run == nil)
+
+	assert.EqualString(t, deadMansSwitchToAlert(*dms, t0.Add(27*time.Hour)).Details, `Check-in late by 1h0m0s (2019-09-08T14:00:00Z)
+
+Recent check-ins:
+- 2019-09-07T12:17:00Z [ran 17m0s]`)
+}
+
+func TestDeadmansswitchReportFailure(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchCreated(
+			"backup",
+			t0.Add(24*time.Hour),
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	alertRaised, err := deadmansswitchReportFailure(ctx, "backup", time.Time{}, "exit code 1: disk full", app, t0.Add(time.Hour))
+	assert.Ok(t, err)
+	assert.Assert(t, alertRaised)
+
+	// deduplicated
+	alertRaised, err = deadmansswitchReportFailure(ctx, "backup", time.Time{}, "exit code 1: disk full", app, t0.Add(2*time.Hour))
+	assert.Ok(t, err)
+	assert.Assert(t, !alertRaised)
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	alert := amstate.FindAlertWithSubject("backup", app.State.ActiveAlerts())
+	// the reported failure itself is among recent check-ins
+	assert.EqualString(t, alert.Details, "Job reported failure: exit code 1: disk full\n\nRecent check-ins:\n- 2019-09-07T13:00:00Z FAILED exit code 1: disk full")
+
+	dms := amstate.FindDeadMansSwitchWithSubject("backup", app.State.DeadMansSwitches())
+	assert.Assert(t, dms.Ttl.Equal(t0.Add(24*time.Hour)))
+
+	// unknown switch needs ttl to be created
+	_, err = deadmansswitchReportFailure(ctx, "report", time.Time{}, "", app, t0)
+	assert.Assert(t, err == errTtlRequired)
+
+	alertAcked, err := deadmansswitchCheckin(ctx, "backup", t0.Add(48*time.Hour), "", nil, app, t0.Add(3*time.Hour))
+	assert.Ok(t, err)
+	assert.Assert(t, alertAcked)
+}
+
+func TestDeadmansswitchPauseOrResume(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchCreated(
+			"backup",
+			t0.Add(24*time.Hour),
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	pauseOrResume := func(pause bool, until *time.Time, ttlSpec string, now time.Time) string {
+		if err := deadmansswitchPauseOrResume(ctx, "backup", pause, until, ttlSpec, app, now); err != nil {
+			return err.Error()
+		}
+
+		assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+		dms := amstate.FindDeadMansSwitchWithSubject("backup", app.State.DeadMansSwitches())
+		return strings.TrimSpace(describePause(*dms, now) + " deadline=" + dms.Ttl.Format(time.RFC3339))
+	}
+
+	past := t0.Add(-time.Hour)
+	resumeAt := t0.Add(14 * 24 * time.Hour)
+
+	assert.EqualString(t, pauseOrResume(false, nil, "", t0), "switch left unchanged: backup")
+	assert.EqualString(t, pauseOrResume(true, &past, "", t0), "resume time is in the past: 2019-09-07T11:00:00Z")
+	assert.EqualString(t, pauseOrResume(true, nil, "+1h", t0), "ttl only makes sense with auto-resume time")
+	assert.EqualString(t, pauseOrResume(true, nil, "", t0), "until resumed deadline=2019-09-08T12:00:00Z")
+
+	// deadline passed during the pause
+	assert.EqualString(t, pauseOrResume(false, nil, "", t0.Add(48*time.Hour)), "deadline 2019-09-08T12:00:00Z is not after resume; give ttl")
+	assert.EqualString(t, pauseOrResume(false, nil, "+2h", t0.Add(48*time.Hour)), "deadline=2019-09-09T14:00:00Z")
+
+	// ttl is relative to auto-resume time
+	assert.EqualString(t, pauseOrResume(true, &resumeAt, "", t0.Add(48*time.Hour)), "deadline 2019-09-09T14:00:00Z is not after resume; give ttl")
+	assert.EqualString(t, pauseOrResume(true, &resumeAt, "+1h", t0.Add(48*time.Hour)), "until 2019-09-21T12:00:00Z deadline=2019-09-21T13:00:00Z")
+
+	// deadline still in the future stays as is
+	assert.EqualString(t, pauseOrResume(false, nil, "", t0.Add(72*time.Hour)), "deadline=2019-09-21T13:00:00Z")
+
+	// scheduled switch gets next deadline from its schedule
+	assert.Ok(t, deadmansswitchSchedule(ctx, "backup", "0 3 * * *", 45*time.Minute, app, t0.Add(72*time.Hour)))
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+	assert.EqualString(t, pauseOrResume(true, nil, "", t0.Add(72*time.Hour)), "until resumed deadline=2019-09-11T03:45:00Z")
+	assert.EqualString(t, pauseOrResume(false, nil, "", t0.Add(10*24*time.Hour)), "deadline=2019-09-18T03:45:00Z")
+
+	assert.Assert(t, len(amstate.GetExpiredDeadMansSwitches(app.State.DeadMansSwitches(), t0.Add(10*24*time.Hour))) == 0)
+
+	assert.EqualString(t, deadmansswitchPauseOrResume(ctx, "report", true, nil, "", app, t0).Error(), "switch not found: report")
+}
+
+func TestDeadmansswitchCreate(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewUnnoticedAlertsNotified(
+			[]string{"dummyid"},
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	metadata := deadMansSwitchMetadata{
+		Description: "Nightly backup of customer database",
+		Owner:       "ops@example.com",
+		Runbook:     "https://example.com/runbooks/backup",
+	}
+
+	assert.EqualString(t, deadmansswitchCreate(ctx, "backup", t0.Add(-time.Hour), metadata, app, t0).Error(), "first deadline is in the past: 2019-09-07T11:00:00Z")
+
+	assert.Ok(t, deadmansswitchCreate(ctx, "backup", t0.Add(18*time.Hour), metadata, app, t0))
+	assert.Ok(t, deadmansswitchCreate(ctx, "report", t0.Add(18*time.Hour), deadMansSwitchMetadata{}, app, t0))
+
+	assert.Assert(t, deadmansswitchCreate(ctx, "backup", t0.Add(18*time.Hour), metadata, app, t0) == errDeadMansSwitchExists)
+
+	assert.EqualString(t, newEventDumper(testStreamName, eventLog, amdomain.Types).Dump(), `
+2019-09-07T12:00:00.000Z UnnoticedAlertsNotified    {"AlertIds":["dummyid"]}
+2019-09-07T12:00:00.000Z DeadMansSwitchCreated    {"Subject":"backup","Ttl":"2019-09-08T06:00:00Z"}
+2019-09-07T12:00:00.000Z DeadMansSwitchMetadataUpdated    {"Subject":"backup","Description":"Nightly backup of customer database","Owner":"ops@example.com","Runbook":"https://example.com/runbooks/backup"}
+2019-09-07T12:00:00.000Z DeadMansSwitchCreated    {"Subject":"report","Ttl":"2019-09-08T06:00:00Z"}`)
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	dms := amstate.FindDeadMansSwitchWithSubject("backup", app.State.DeadMansSwitches())
+
+	// never checked in
+	assert.EqualString(t, deadMansSwitchToAlert(*dms, t0.Add(19*time.Hour)).Details, `Check-in late by 1h0m0s (2019-09-08T06:00:00Z)
+
+Description: Nightly backup of customer database
+Owner: ops@example.com
+Runbook: https://example.com/runbooks/backup`)
+}
+
+func TestCheckinPayload(t *testing.T) {
+	fields, err := parseCheckinFields([]string{"size_gb=42.5", "files=1000"})
+	assert.Ok(t, err)
+	assert.EqualJson(t, fields, `{
+  "files": 1000,
+  "size_gb": 42.5
+}`)
+
+	_, err = parseCheckinFields([]string{"size_gb"})
+	assert.EqualString(t, err.Error(), "field not in name=value format: size_gb")
+
+	_, err = parseCheckinFields([]string{"size_gb=lots"})
+	assert.EqualString(t, err.Error(), "field size_gb: value must be a number")
+
+	fields, err = parseCheckinFields([]string{"size_gb=NaN"})
+	assert.Ok(t, err)
+	assert.EqualString(t, validateCheckinPayload("", fields).Error(), "field size_gb: value must be a finite number")
+
+	assert.EqualString(t, validateCheckinPayload(strings.Repeat("x", 1025), nil).Error(), "message too long (1025 > 1024)")
+	assert.Ok(t, validateCheckinPayload("ok", map[string]float64{"size_gb": 42}))
+
+	assert.EqualString(t, describeCheckin(amstate.DeadMansSwitchCheckin{Message: "ok"}), "ok")
+	assert.EqualString(t, describeCheckin(amstate.DeadMansSwitchCheckin{Fields: map[string]float64{"b": 2, "a": 1.5}}), "(a=1.5, b=2)")
+	assert.EqualString(t, describeCheckin(amstate.DeadMansSwitchCheckin{Message: "ok", Duration: time.Minute}), "ok [ran 1m0s]")
+	assert.EqualString(t, describeCheckin(amstate.DeadMansSwitchCheckin{Failed: true}), "FAILED")
 }
 
 func newEventDumper(stream string, eventLog ehclient.Reader, types ehevent.Allocators) *eventDumper {
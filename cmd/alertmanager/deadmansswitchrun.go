@@ -0,0 +1,189 @@
+package main
+
+// "dms run" wraps a cron job, so the job itself needs no knowledge of us. talks to us over REST
+// (so the host only needs ALERTMANAGER_BASEURL, not EventHorizon credentials).
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/lambda-alertmanager/pkg/alertmanagerclient"
+	"github.com/function61/lambda-alertmanager/pkg/alertmanagertypes"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// output is kept for the check-in message, which has a length limit anyway
+	jobOutputTailLength = 4 * 1024
+	// final check-in can't use the job's context, which is cancelled when we're interrupted
+	finalCheckinTimeout = 30 * time.Second
+)
+
+func deadmansswitchRunEntry() *cobra.Command {
+	subject := ""
+	ttlSpec := ""
+	maxRuntime := time.Duration(0)
+
+	cmd := &cobra.Command{
+		Use:   "run -- [command] [args...]",
+		Short: "Run a job and check in if it succeeds, report failure if not (needs ALERTMANAGER_BASEURL)",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			if subject == "" {
+				exitIfError(errors.New("--subject required"))
+			}
+
+			client, err := alertmanagerclient.ClientFromEnvRequired()
+			exitIfError(err)
+
+			exitCode, err := runJobAndReport(
+				ctx,
+				subject,
+				ttlSpec,
+				maxRuntime,
+				args,
+				client,
+				os.Stdout,
+				os.Stderr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				if exitCode == 0 {
+					exitCode = 1
+				}
+			}
+
+			// so cron etc. see the job's outcome
+			os.Exit(exitCode)
+		},
+	}
+
+	cmd.Flags().StringVarP(&subject, "subject", "s", subject, "Subject of the switch")
+	cmd.Flags().StringVarP(&ttlSpec, "ttl", "", ttlSpec, "Deadline of next check-in, e.g. +25h (not needed if switch has a schedule)")
+	cmd.Flags().DurationVarP(&maxRuntime, "max-runtime", "", maxRuntime, "If set, alert if the job runs longer than this")
+
+	return cmd
+}
+
+// returns the command's exit code. stdout and stderr of the command are passed through.
+func runJobAndReport(
+	ctx context.Context,
+	subject string,
+	ttlSpec string,
+	maxRuntime time.Duration,
+	command []string,
+	client *alertmanagerclient.Client,
+	stdout io.Writer,
+	stderr io.Writer,
+) (int, error) {
+	// monitoring being down must not stop the job from running
+	if maxRuntime > 0 {
+		if err := client.DeadMansSwitchStart(ctx, subject, maxRuntime); err != nil {
+			fmt.Fprintf(stderr, "DeadMansSwitchStart (running the job anyway): %v\n", err)
+		}
+	}
+
+	output := &tailWriter{max: jobOutputTailLength}
+
+	job := exec.CommandContext(ctx, command[0], command[1:]...)
+	job.Stdin = os.Stdin
+	job.Stdout = io.MultiWriter(stdout, output)
+	job.Stderr = io.MultiWriter(stderr, output)
+
+	started := time.Now()
+	runErr := job.Run()
+	duration := time.Since(started).Round(time.Millisecond)
+
+	req := alertmanagertypes.NewDeadMansSwitchCheckinRequest(subject, ttlSpec)
+
+	exitCode := 0
+	if runErr != nil {
+		exitCode = 1 // for when command didn't even start
+		if exitErr, is := runErr.(*exec.ExitError); is && exitErr.ExitCode() > 0 {
+			exitCode = exitErr.ExitCode()
+		}
+
+		req.Status = alertmanagertypes.CheckinStatusFail
+		req.Message = jobCheckinMessage(fmt.Sprintf("%v after %s", runErr, duration), output.String())
+	} else {
+		req.Message = jobCheckinMessage("", output.String())
+		req.Fields = map[string]float64{
+			"duration_seconds": duration.Seconds(),
+		}
+	}
+
+	// job killed by SIGTERM etc. has cancelled ctx, but its failure must still be reported
+	checkinCtx, cancel := context.WithTimeout(context.Background(), finalCheckinTimeout)
+	defer cancel()
+
+	if err := client.DeadMansSwitchCheckinCustom(checkinCtx, req); err != nil {
+		return exitCode, fmt.Errorf("DeadMansSwitchCheckin: %w", err)
+	}
+
+	return exitCode, nil
+}
+
+// summary followed by as much of the output's end as fits in a check-in message
+func jobCheckinMessage(summary string, output string) string {
+	const ellipsis = "..."
+	const minOutputRoom = 256 // long summary (e.g. exec error with long path) mustn't hide all output
+
+	output = strings.TrimSpace(output)
+
+	separator := ""
+	if summary != "" && output != "" {
+		separator = "\n\n"
+	}
+
+	maxSummary := maxCheckinMessageLength
+	if output != "" {
+		maxSummary -= len(separator) + minOutputRoom
+	}
+
+	if len(summary) > maxSummary {
+		summary = strings.ToValidUTF8(summary[:maxSummary-len(ellipsis)], "") + ellipsis
+	}
+
+	room := maxCheckinMessageLength - len(summary) - len(separator)
+	if len(output) > room {
+		output = ellipsis + strings.ToValidUTF8(output[len(output)-(room-len(ellipsis)):], "")
+	}
+
+	return summary + separator + output
+}
+
+// keeps last max bytes written to it. safe for concurrent use, since exec writes stdout and
+// stderr from different goroutines.
+type tailWriter struct {
+	max  int
+	buf  []byte
+	lock sync.Mutex
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append([]byte{}, t.buf[len(t.buf)-t.max:]...)
+	}
+
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return strings.ToValidUTF8(string(t.buf), "")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/alertmanagerclient"
+)
+
+func TestRunJobAndReport(t *testing.T) {
+	requests := []string{}
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Ok(t, err)
+
+		// duration varies, so drop it
+		req := map[string]interface{}{}
+		assert.Ok(t, json.Unmarshal(body, &req))
+		delete(req, "fields")
+		normalized, err := json.Marshal(req)
+		assert.Ok(t, err)
+
+		requests = append(requests, r.URL.Path+" "+string(normalized))
+	}))
+	defer api.Close()
+
+	client := alertmanagerclient.New(api.URL)
+
+	run := func(maxRuntime time.Duration, command ...string) int {
+		requests = []string{}
+
+		exitCode, err := runJobAndReport(
+			context.Background(),
+			"backup",
+			"+25h",
+			maxRuntime,
+			command,
+			client,
+			ioutil.Discard,
+			ioutil.Discard)
+		assert.Ok(t, err)
+
+		return exitCode
+	}
+
+	assert.Assert(t, run(0, "sh", "-c", "echo backed up 42 GB") == 0)
+	assert.EqualString(t, strings.Join(requests, "\n"), `/deadmansswitch/checkin {"message":"backed up 42 GB","subject":"backup","ttl":"+25h"}`)
+
+	assert.Assert(t, run(2*time.Hour, "sh", "-c", "echo disk full >&2; exit 3") == 3)
+	assert.Assert(t, len(requests) == 2)
+	assert.EqualString(t, requests[0], `/deadmansswitch/start {"max_runtime":"2h0m0s","subject":"backup"}`)
+	assert.Assert(t, strings.HasPrefix(requests[1], `/deadmansswitch/checkin {"message":"exit status 3 after `))
+	assert.Assert(t, strings.HasSuffix(requests[1], `\n\ndisk full","status":"fail","subject":"backup","ttl":"+25h"}`))
+
+	assert.Assert(t, run(0, "/nonexistent/backup.sh") == 1)
+	assert.Assert(t, strings.Contains(requests[0], `"status":"fail"`))
+}
+
+func TestRunJobReportsFailureWhenInterrupted(t *testing.T) {
+	requests := []string{}
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Ok(t, err)
+
+		requests = append(requests, r.URL.Path+" "+string(body))
+	}))
+	defer api.Close()
+
+	// like SIGTERM while the job runs
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	exitCode, err := runJobAndReport(
+		ctx,
+		"backup",
+		"+25h",
+		0,
+		[]string{"sleep", "10"},
+		alertmanagerclient.New(api.URL),
+		ioutil.Discard,
+		ioutil.Discard)
+	assert.Ok(t, err)
+	assert.Assert(t, exitCode == 1)
+	assert.Assert(t, len(requests) == 1)
+	assert.Assert(t, strings.HasPrefix(requests[0], "/deadmansswitch/checkin "))
+	assert.Assert(t, strings.Contains(requests[0], `"status":"fail"`))
+	assert.Assert(t, strings.Contains(requests[0], `"message":"signal: killed after `))
+}
+
+func TestJobCheckinMessage(t *testing.T) {
+	assert.EqualString(t, jobCheckinMessage("", "  \n"), "")
+	assert.EqualString(t, jobCheckinMessage("exit status 1 after 1s", ""), "exit status 1 after 1s")
+
+	message := jobCheckinMessage("exit status 1 after 1s", strings.Repeat("x", 2000)+"END")
+	assert.Assert(t, len(message) == maxCheckinMessageLength)
+	assert.Assert(t, strings.HasPrefix(message, "exit status 1 after 1s\n\n...xxx"))
+	assert.Assert(t, strings.HasSuffix(message, "xxxEND"))
+}
+
+func TestJobCheckinMessageLongSummary(t *testing.T) {
+	longSummary := `exec: "/` + strings.Repeat("very/long/path/", 100) + `backup.sh": executable file not found`
+
+	message := jobCheckinMessage(longSummary, "")
+	assert.Assert(t, len(message) == maxCheckinMessageLength)
+	assert.Assert(t, strings.HasPrefix(message, `exec: "/very/long/path/`) && strings.HasSuffix(message, "..."))
+
+	message = jobCheckinMessage(longSummary, strings.Repeat("y", 2000))
+	assert.Assert(t, len(message) == maxCheckinMessageLength)
+	assert.Assert(t, strings.HasSuffix(message, "...\n\n..."+strings.Repeat("y", 253)))
+
+	message = jobCheckinMessage(strings.Repeat("x", maxCheckinMessageLength), "")
+	assert.EqualString(t, message, strings.Repeat("x", maxCheckinMessageLength))
+}
+
+func TestRunJobWhenStartReportFails(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deadmansswitch/start" {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	defer api.Close()
+
+	stderr := &strings.Builder{}
+
+	exitCode, err := runJobAndReport(
+		context.Background(),
+		"backup",
+		"+25h",
+		2*time.Hour,
+		[]string{"sh", "-c", "echo ran >&2"},
+		alertmanagerclient.New(api.URL),
+		ioutil.Discard,
+		stderr)
+	assert.Ok(t, err)
+	assert.Assert(t, exitCode == 0)
+	assert.Assert(t, strings.HasPrefix(stderr.String(), "DeadMansSwitchStart (running the job anyway): "))
+	assert.Assert(t, strings.HasSuffix(stderr.String(), "\nran\n"))
+}
+
+func TestTailWriter(t *testing.T) {
+	tail := &tailWriter{max: 5}
+	_, _ = tail.Write([]byte("abc"))
+	_, _ = tail.Write([]byte("defg"))
+	assert.EqualString(t, tail.String(), "cdefg")
+}
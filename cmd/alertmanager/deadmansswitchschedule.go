@@ -0,0 +1,117 @@
+package main
+
+// Switches with a cron schedule get their next deadline computed by us after each check-in, so
+// the job doesn't have to know when it runs next.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/robfig/cron/v3"
+)
+
+const defaultScheduleGrace = 15 * time.Minute
+
+var errTtlRequired = errors.New("ttl required (switch has no schedule)")
+
+// "0 3 * * *", "@daily" or "CRON_TZ=Europe/Helsinki 0 3 * * *" (UTC if no time zone given)
+func parseDeadMansSwitchSchedule(schedule string) (cron.Schedule, error) {
+	parsed, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %w", err)
+	}
+
+	return parsed, nil
+}
+
+// next scheduled time after a check-in (or creation) + grace
+func nextScheduledDeadline(schedule string, grace time.Duration, after time.Time) (time.Time, error) {
+	parsed, err := parseDeadMansSwitchSchedule(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := parsed.Next(after.UTC())
+	if next.IsZero() { // e.g. "0 0 30 2 *"
+		return time.Time{}, fmt.Errorf("schedule never fires: %s", schedule)
+	}
+
+	return next.Add(grace).UTC(), nil
+}
+
+func validateDeadMansSwitchSchedule(schedule string, grace time.Duration, now time.Time) error {
+	if grace <= 0 {
+		return errors.New("grace must be positive (jobs don't check in at the exact scheduled time)")
+	}
+
+	_, err := nextScheduledDeadline(schedule, grace, now)
+	return err
+}
+
+// creates switch if it doesn't exist. empty schedule removes schedule (deadline stays as is).
+func deadmansswitchSchedule(
+	ctx context.Context,
+	subject string,
+	schedule string,
+	grace time.Duration,
+	app *amstate.App,
+	now time.Time,
+) error {
+	return app.Reader.TransactWrite(ctx, func() error {
+		existing := amstate.FindDeadMansSwitchWithSubject(subject, app.State.DeadMansSwitches())
+
+		if schedule == "" {
+			if existing == nil {
+				return fmt.Errorf("switch not found: %s", subject)
+			}
+
+			return app.AppendAfter(ctx, app.State.Version(), amdomain.NewDeadMansSwitchScheduleUpdated(
+				subject,
+				"",
+				0,
+				existing.Ttl,
+				ehevent.MetaSystemUser(now)))
+		}
+
+		if err := validateDeadMansSwitchSchedule(schedule, grace, now); err != nil {
+			return err
+		}
+
+		ttl, err := nextScheduledDeadline(schedule, grace, now)
+		if err != nil {
+			return err
+		}
+
+		events := []ehevent.Event{}
+
+		if existing == nil {
+			events = append(events, amdomain.NewDeadMansSwitchCreated(
+				subject,
+				ttl,
+				ehevent.MetaSystemUser(now)))
+		}
+
+		events = append(events, amdomain.NewDeadMansSwitchScheduleUpdated(
+			subject,
+			schedule,
+			grace,
+			ttl,
+			ehevent.MetaSystemUser(now)))
+
+		return app.AppendAfter(ctx, app.State.Version(), events...)
+	})
+}
+
+// "0 3 * * * +45m"
+func describeSchedule(schedule string, grace time.Duration) string {
+	if schedule == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s +%s", schedule, grace)
+}
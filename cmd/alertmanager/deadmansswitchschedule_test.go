@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/eventhorizon/pkg/ehreader"
+	"github.com/function61/eventhorizon/pkg/ehreader/ehreadertest"
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestNextScheduledDeadline(t *testing.T) {
+	next := func(schedule string, after string) string {
+		afterTs, err := time.Parse(time.RFC3339, after)
+		assert.Ok(t, err)
+
+		deadline, err := nextScheduledDeadline(schedule, 45*time.Minute, afterTs)
+		if err != nil {
+			return err.Error()
+		}
+		return deadline.Format(time.RFC3339)
+	}
+
+	assert.EqualString(t, next("0 3 * * *", "2020-01-10T02:00:00Z"), "2020-01-10T03:45:00Z")
+	assert.EqualString(t, next("0 3 * * *", "2020-01-10T03:10:00Z"), "2020-01-11T03:45:00Z")
+	assert.EqualString(t, next("@daily", "2020-01-10T03:10:00Z"), "2020-01-11T00:45:00Z")
+
+	// month ends. months without 31st are skipped
+	assert.EqualString(t, next("0 3 * * *", "2020-01-31T04:00:00Z"), "2020-02-01T03:45:00Z")
+	assert.EqualString(t, next("0 3 31 * *", "2020-01-31T04:00:00Z"), "2020-03-31T03:45:00Z")
+	assert.EqualString(t, next("0 3 1 * *", "2020-12-31T04:00:00Z"), "2021-01-01T03:45:00Z")
+	assert.EqualString(t, next("0 23 30 * *", "2021-02-01T00:00:00Z"), "2021-03-30T23:45:00Z")
+
+	// leap days
+	assert.EqualString(t, next("0 3 * * *", "2020-02-28T23:00:00Z"), "2020-02-29T03:45:00Z")
+	assert.EqualString(t, next("0 3 * * *", "2021-02-28T23:00:00Z"), "2021-03-01T03:45:00Z")
+	assert.EqualString(t, next("0 0 29 2 *", "2020-03-01T00:00:00Z"), "2024-02-29T00:45:00Z")
+
+	// grace crosses midnight
+	assert.EqualString(t, next("30 23 * * *", "2020-02-28T12:00:00Z"), "2020-02-29T00:15:00Z")
+
+	assert.EqualString(t, next("CRON_TZ=Europe/Helsinki 0 3 * * *", "2020-01-10T00:00:00Z"), "2020-01-10T01:45:00Z")
+
+	assert.EqualString(t, next("0 0 30 2 *", "2020-01-01T00:00:00Z"), "schedule never fires: 0 0 30 2 *")
+	assert.EqualString(t, next("0 3 * *", "2020-01-01T00:00:00Z"), "schedule: expected exactly 5 fields, found 4: [0 3 * *]")
+}
+
+func TestScheduledDeadmansswitchCheckin(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewUnnoticedAlertsNotified(
+			[]string{"dummyid"},
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	// no schedule and no ttl
+	_, err = deadmansswitchCheckin(ctx, "backup", time.Time{}, "", nil, app, t0)
+	assert.Assert(t, err == errTtlRequired)
+
+	assert.EqualString(t, deadmansswitchSchedule(ctx, "backup", "0 3 * * *", 0, app, t0).Error(), "grace must be positive (jobs don't check in at the exact scheduled time)")
+
+	assert.Ok(t, deadmansswitchSchedule(ctx, "backup", "0 3 * * *", 45*time.Minute, app, t0))
+
+	// ttl given by the job is ignored in favour of the schedule
+	_, err = deadmansswitchCheckin(ctx, "backup", t0.Add(48*time.Hour), "", nil, app, t0.Add(15*time.Hour+20*time.Minute))
+	assert.Ok(t, err)
+
+	assert.Ok(t, deadmansswitchSchedule(ctx, "backup", "", 0, app, t0.Add(16*time.Hour)))
+
+	assert.EqualString(t, newEventDumper(testStreamName, eventLog, amdomain.Types).Dump(), `
+2019-09-07T12:00:00.000Z UnnoticedAlertsNotified    {"AlertIds":["dummyid"]}
+2019-09-07T12:00:00.000Z DeadMansSwitchCreated    {"Subject":"backup","Ttl":"2019-09-08T03:45:00Z"}
+2019-09-07T12:00:00.000Z DeadMansSwitchScheduleUpdated    {"Subject":"backup","Schedule":"0 3 * * *","Grace":2700000000000,"Ttl":"2019-09-08T03:45:00Z"}
+2019-09-08T03:20:00.000Z DeadMansSwitchCheckin    {"Subject":"backup","Ttl":"2019-09-09T03:45:00Z"}
+2019-09-08T04:00:00.000Z DeadMansSwitchScheduleUpdated    {"Subject":"backup","Schedule":"","Grace":0,"Ttl":"2019-09-09T03:45:00Z"}`)
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	dms := amstate.FindDeadMansSwitchWithSubject("backup", app.State.DeadMansSwitches())
+	assert.EqualString(t, describeSchedule(dms.Schedule, dms.Grace), "")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+type dnsLookupFn func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error)
+
+var dnsLookups = map[string]dnsLookupFn{
+	"A": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		return lookupIps(ctx, resolver, name, "ip4")
+	},
+	"AAAA": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		return lookupIps(ctx, resolver, name, "ip6")
+	},
+	"CNAME": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{cname}, nil
+	},
+	"MX": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		mxs, err := resolver.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		hosts := []string{}
+		for _, mx := range mxs {
+			hosts = append(hosts, mx.Host)
+		}
+		return hosts, nil
+	},
+	"NS": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		nss, err := resolver.LookupNS(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		hosts := []string{}
+		for _, ns := range nss {
+			hosts = append(hosts, ns.Host)
+		}
+		return hosts, nil
+	},
+	"TXT": func(ctx context.Context, resolver *net.Resolver, name string) ([]string, error) {
+		return resolver.LookupTXT(ctx, name)
+	},
+}
+
+// checks that a DNS name resolves, and optionally that the answer contains expected values
+type dnsScanner struct {
+	resolver *net.Resolver
+}
+
+func newDnsScanner() *dnsScanner {
+	return &dnsScanner{net.DefaultResolver}
+}
+
+func (d *dnsScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	lookup, found := dnsLookups[monitor.DnsRecordType]
+	if !found {
+		return scanResult{}, fmt.Errorf("unsupported DNS record type: %s", monitor.DnsRecordType)
+	}
+
+	values, err := lookup(ctx, d.resolver, monitor.Target)
+	if err != nil {
+		return scanResult{}, err
+	}
+
+	return scanResult{}, mustHaveExpectedDnsValues(values, monitor.Expect)
+}
+
+func mustHaveExpectedDnsValues(values []string, expect []string) error {
+	if len(values) == 0 {
+		return errors.New("no records in DNS answer")
+	}
+
+	has := map[string]bool{}
+	for _, value := range values {
+		has[normalizeDnsValue(value)] = true
+	}
+
+	missing := []string{}
+	for _, expected := range expect {
+		if !has[normalizeDnsValue(expected)] {
+			missing = append(missing, expected)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			"expected value(s) %s NOT in DNS answer: %s",
+			strings.Join(missing, ", "),
+			strings.Join(values, ", "))
+	}
+
+	return nil
+}
+
+// "Mail.Example.com." => "mail.example.com"
+func normalizeDnsValue(value string) string {
+	return strings.TrimSuffix(strings.ToLower(value), ".")
+}
+
+func lookupIps(ctx context.Context, resolver *net.Resolver, name string, network string) ([]string, error) {
+	addrs, err := resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []string{}
+	for _, addr := range addrs {
+		isV4 := addr.IP.To4() != nil
+		if (network == "ip4") == isV4 {
+			ips = append(ips, addr.IP.String())
+		}
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/ezhttp"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	// "refer:        whois.verisign-grs.com"
+	whoisReferRe = regexp.MustCompile(`(?im)^\s*(?:refer|whois server|registrar whois server)\s*:\s*(\S+)\s*$`)
+	// registries don't agree on a format. "expires............: 1.1.2030"
+	whoisExpiryRe = regexp.MustCompile(`(?im)^\s*(?:registry expiry date|registrar registration expiration date|expiration date|expiry date|expire date|expires on|expires|paid-till|renewal date)[\s.]*:\s*(.+?)\s*$`)
+)
+
+var whoisDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02",
+	"2006.01.02",
+	"2006/01/02",
+	"02-Jan-2006",
+	"02.01.2006",
+	"2.1.2006 15:04:05", // .fi
+	"2.1.2006",
+}
+
+type domainExpiryScanner struct {
+	rdapBaseUrl string // bootstrap service that redirects to the TLD's RDAP server
+	whoisServer string // host:port. refers to the TLD's WHOIS server
+	client      *http.Client
+}
+
+func newDomainExpiryScanner(opts scannerOptions) *domainExpiryScanner {
+	return &domainExpiryScanner{
+		rdapBaseUrl: "https://rdap.org",
+		whoisServer: "whois.iana.org:43",
+		// follows redirects, since bootstrap service redirects
+		client: &http.Client{Transport: newHttpScanner(opts).noRedirects.Transport},
+	}
+}
+
+func (d *domainExpiryScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	domain, err := registrableDomain(monitor.Target)
+	if err != nil {
+		return scanResult{}, err
+	}
+
+	now := time.Now()
+
+	result := scanResult{}
+
+	expires := monitor.CachedDomainExpiry(now)
+	if expires == nil {
+		lookedUp, err := d.lookupExpiry(ctx, domain)
+		if err != nil {
+			// failure gets cached too, so we back off from asking the registries
+			result.domainExpires = &time.Time{}
+			return result, err
+		}
+
+		expires = &lookedUp
+		result.domainExpires = &lookedUp // so it gets cached
+	} else if expires.IsZero() {
+		return result, fmt.Errorf(
+			"expiry of %s: lookup failed, retrying after %s",
+			domain,
+			monitor.DomainExpiry.NextLookup().UTC().Format(time.RFC3339))
+	}
+
+	return result, mustNotExpireSoon(domain, *expires, monitor.GetExpiryAlertDays(), now)
+}
+
+func mustNotExpireSoon(domain string, expires time.Time, alertDays int, now time.Time) error {
+	left := expires.Sub(now)
+
+	switch {
+	case left <= 0:
+		return fmt.Errorf("domain %s expired on %s", domain, expires.Format("2006-01-02"))
+	case left < time.Duration(alertDays)*24*time.Hour:
+		return fmt.Errorf(
+			"domain %s expires in %d day(s) on %s",
+			domain,
+			int(left.Hours()/24),
+			expires.Format("2006-01-02"))
+	default:
+		return nil
+	}
+}
+
+// RDAP is the structured successor of WHOIS, but not all TLDs have it yet
+func (d *domainExpiryScanner) lookupExpiry(ctx context.Context, domain string) (time.Time, error) {
+	expires, rdapErr := d.rdapExpiry(ctx, domain)
+	if rdapErr == nil {
+		return expires, nil
+	}
+
+	expires, whoisErr := d.whoisExpiry(ctx, domain)
+	if whoisErr != nil {
+		return time.Time{}, fmt.Errorf("expiry of %s: RDAP: %v; WHOIS: %v", domain, rdapErr, whoisErr)
+	}
+
+	return expires, nil
+}
+
+func (d *domainExpiryScanner) rdapExpiry(ctx context.Context, domain string) (time.Time, error) {
+	response := struct {
+		Events []struct {
+			EventAction string    `json:"eventAction"`
+			EventDate   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}{}
+
+	if _, err := ezhttp.Get(
+		ctx,
+		d.rdapBaseUrl+"/domain/"+url.PathEscape(domain),
+		ezhttp.Header("Accept", "application/rdap+json"),
+		ezhttp.RespondsJson(&response, true),
+		ezhttp.Client(d.client),
+	); err != nil {
+		return time.Time{}, err
+	}
+
+	for _, event := range response.Events {
+		if event.EventAction == "expiration" {
+			return event.EventDate, nil
+		}
+	}
+
+	return time.Time{}, errors.New("no expiration event in response")
+}
+
+// asks the root server which server to ask, then asks that one
+func (d *domainExpiryScanner) whoisExpiry(ctx context.Context, domain string) (time.Time, error) {
+	server := d.whoisServer
+
+	for hops := 0; hops < 3; hops++ {
+		response, err := whoisQuery(ctx, server, domain)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if match := whoisExpiryRe.FindStringSubmatch(response); match != nil {
+			return parseWhoisDate(match[1])
+		}
+
+		refer := whoisReferRe.FindStringSubmatch(response)
+		if refer == nil {
+			return time.Time{}, fmt.Errorf("%s: no expiry date in response", server)
+		}
+
+		server = refer[1]
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "43")
+		}
+	}
+
+	return time.Time{}, errors.New("too many referrals")
+}
+
+func whoisQuery(ctx context.Context, server string, query string) (string, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if deadline, has := ctx.Deadline(); has {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s\r\n", query); err != nil {
+		return "", err
+	}
+
+	// server closes the connection after responding
+	response, err := ioutil.ReadAll(io.LimitReader(conn, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	return string(response), nil
+}
+
+func parseWhoisDate(value string) (time.Time, error) {
+	// some have trailing garbage like " (YYYY-MM-DD)"
+	candidates := append([]string{value}, strings.Fields(value)...)
+
+	for _, candidate := range candidates {
+		for _, layout := range whoisDateLayouts {
+			if ts, err := time.Parse(layout, candidate); err == nil {
+				return ts, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported date format: %s", value)
+}
+
+// "https://www.example.co.uk/foo" => "example.co.uk". target can also be a hostname.
+func registrableDomain(target string) (string, error) {
+	hostname := target
+	if strings.Contains(target, "://") {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return "", err
+		}
+
+		hostname = parsed.Hostname()
+	}
+
+	if hostname == "" || net.ParseIP(hostname) != nil {
+		return "", fmt.Errorf("not a domain: %s", target)
+	}
+
+	return publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(strings.ToLower(hostname), "."))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestDomainExpiryScanner(t *testing.T) {
+	now := time.Now().UTC()
+
+	expiresIn := func(days int) time.Time {
+		return now.Add(time.Duration(days)*24*time.Hour + time.Hour).Truncate(time.Second)
+	}
+
+	rdapRequests := 0
+
+	// stand-in for RDAP bootstrap service
+	rdap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rdapRequests++
+
+		expirations := map[string]time.Time{
+			"/domain/example.com": expiresIn(100),
+			"/domain/example.net": expiresIn(10),
+			"/domain/example.org": now.Add(-48 * time.Hour).Truncate(time.Second),
+		}
+
+		expires, found := expirations[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/rdap+json")
+		fmt.Fprintf(w, `{
+	"objectClassName": "domain",
+	"ldhName": "%s",
+	"events": [
+		{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+		{"eventAction": "expiration", "eventDate": "%s"}
+	]
+}`, strings.TrimPrefix(r.URL.Path, "/domain/"), expires.Format(time.RFC3339))
+	}))
+	defer rdap.Close()
+
+	// .fi is not in our stand-in RDAP, so WHOIS is asked (root refers to registry's server)
+	registryWhois := whoisServer(t, func(query string) string {
+		if query != "example.fi" {
+			return "Domain not found\n"
+		}
+
+		return fmt.Sprintf("domain.............: %s\nexpires............: %s\n", query, expiresIn(5).Format("2.1.2006 15:04:05"))
+	})
+	defer registryWhois.Close()
+
+	rootWhois := whoisServer(t, func(query string) string {
+		return fmt.Sprintf("%% IANA WHOIS server\n\nrefer:        %s\n", registryWhois.Addr().String())
+	})
+	defer rootWhois.Close()
+
+	scanner := &domainExpiryScanner{
+		rdapBaseUrl: rdap.URL,
+		whoisServer: rootWhois.Addr().String(),
+		client:      http.DefaultClient,
+	}
+
+	scan := func(monitor amstate.Monitor) (scanResult, string) {
+		result, err := scanner.Scan(context.Background(), monitor)
+		if err != nil {
+			return result, err.Error()
+		}
+		return result, "ok"
+	}
+
+	domainMonitor := func(target string) amstate.Monitor {
+		return amstate.Monitor{MonitorConfig: amdomain.MonitorConfig{
+			Kind:   amdomain.MonitorKindDomainExpiry,
+			Target: target,
+		}}
+	}
+
+	result, outcome := scan(domainMonitor("https://www.example.com/foo"))
+	assert.EqualString(t, outcome, "ok")
+	assert.Assert(t, result.domainExpires.Equal(expiresIn(100)))
+
+	_, outcome = scan(domainMonitor("https://example.net/"))
+	assert.EqualString(t, outcome, fmt.Sprintf("domain example.net expires in 10 day(s) on %s", expiresIn(10).Format("2006-01-02")))
+
+	lessStrict := domainMonitor("https://example.net/")
+	lessStrict.ExpiryAlertDays = 7
+	_, outcome = scan(lessStrict)
+	assert.EqualString(t, outcome, "ok")
+
+	_, outcome = scan(domainMonitor("example.org"))
+	assert.EqualString(t, outcome, fmt.Sprintf("domain example.org expired on %s", now.Add(-48*time.Hour).Format("2006-01-02")))
+
+	// WHOIS fallback. failing check still caches the lookup
+	result, outcome = scan(domainMonitor("https://www.example.fi/"))
+	assert.EqualString(t, outcome, fmt.Sprintf("domain example.fi expires in 5 day(s) on %s", expiresIn(5).Format("2006-01-02")))
+	assert.Assert(t, result.domainExpires.Equal(expiresIn(5)))
+
+	result, outcome = scan(domainMonitor("https://example.io/"))
+	assert.Assert(t, strings.HasPrefix(outcome, "expiry of example.io: RDAP: "))
+	assert.Assert(t, result.domainExpires.IsZero()) // failure gets cached too
+
+	// cached lookup is used for a day
+	rdapRequests = 0
+
+	cached := domainMonitor("https://example.com/")
+	cached.DomainExpiry = &amstate.DomainExpiry{
+		Expires:  expiresIn(3),
+		LookedUp: now.Add(-23 * time.Hour),
+	}
+
+	result, outcome = scan(cached)
+	assert.EqualString(t, outcome, fmt.Sprintf("domain example.com expires in 3 day(s) on %s", expiresIn(3).Format("2006-01-02")))
+	assert.Assert(t, result.domainExpires == nil)
+	assert.Assert(t, rdapRequests == 0)
+
+	cached.DomainExpiry.LookedUp = now.Add(-25 * time.Hour)
+
+	result, outcome = scan(cached)
+	assert.EqualString(t, outcome, "ok")
+	assert.Assert(t, result.domainExpires.Equal(expiresIn(100)))
+	assert.Assert(t, rdapRequests == 1)
+
+	// failed lookup is not retried right away
+	rdapRequests = 0
+
+	failed := domainMonitor("https://example.com/")
+	failed.DomainExpiry = &amstate.DomainExpiry{
+		LookedUp:      now.Add(-10 * time.Minute),
+		FailedLookups: 1,
+	}
+
+	result, outcome = scan(failed)
+	assert.EqualString(t, outcome, fmt.Sprintf("expiry of example.com: lookup failed, retrying after %s", now.Add(5*time.Minute).Format(time.RFC3339)))
+	assert.Assert(t, result.domainExpires == nil)
+	assert.Assert(t, rdapRequests == 0)
+
+	failed.DomainExpiry.LookedUp = now.Add(-16 * time.Minute)
+
+	result, outcome = scan(failed)
+	assert.EqualString(t, outcome, "ok")
+	assert.Assert(t, rdapRequests == 1)
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	domain := func(target string) string {
+		registrable, err := registrableDomain(target)
+		if err != nil {
+			return err.Error()
+		}
+		return registrable
+	}
+
+	assert.EqualString(t, domain("https://www.example.com/foo"), "example.com")
+	assert.EqualString(t, domain("http://shop.example.co.uk:8080/"), "example.co.uk")
+	assert.EqualString(t, domain("api.Example.COM."), "example.com")
+	assert.EqualString(t, domain("example.com"), "example.com")
+	assert.EqualString(t, domain("https://127.0.0.1/"), "not a domain: https://127.0.0.1/")
+}
+
+func TestParseWhoisDate(t *testing.T) {
+	date := func(value string) string {
+		ts, err := parseWhoisDate(value)
+		if err != nil {
+			return err.Error()
+		}
+		return ts.Format(time.RFC3339)
+	}
+
+	assert.EqualString(t, date("2028-09-13T04:00:00Z"), "2028-09-13T04:00:00Z")
+	assert.EqualString(t, date("2028-09-13 04:00:00 UTC"), "2028-09-13T04:00:00Z")
+	assert.EqualString(t, date("2028-09-13 (renew before)"), "2028-09-13T00:00:00Z")
+	assert.EqualString(t, date("13.9.2028 00:00:00"), "2028-09-13T00:00:00Z")
+	assert.EqualString(t, date("1.1.2030 12:00:00"), "2030-01-01T12:00:00Z")
+	assert.EqualString(t, date("13.9.2028"), "2028-09-13T00:00:00Z")
+	assert.EqualString(t, date("next year"), "unsupported date format: next year")
+	assert.EqualString(t, date("13-Sep-2028"), "2028-09-13T00:00:00Z")
+	assert.EqualString(t, date("2028.09.13"), "2028-09-13T00:00:00Z")
+}
+
+// responds to each query with response(query)
+func whoisServer(t *testing.T, response func(query string) string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Ok(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // closed
+			}
+
+			query, err := bufio.NewReader(conn).ReadString('\n')
+			if err == nil {
+				fmt.Fprint(conn, response(strings.TrimSpace(query)))
+			}
+
+			conn.Close()
+		}
+	}()
+
+	return listener
+}
@@ -0,0 +1,186 @@
+package main
+
+// Implements client side of the standard gRPC health checking protocol (grpc.health.v1).
+// The protocol is simple enough that we speak it directly over HTTP/2 instead of pulling
+// in the whole gRPC stack.
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"golang.org/x/net/http2"
+)
+
+// values of grpc.health.v1.HealthCheckResponse.ServingStatus
+var grpcServingStatuses = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+const grpcServing = 1
+
+type grpcScanner struct {
+	plaintext *http.Client
+	tls       *http.Client
+}
+
+func newGrpcScanner() *grpcScanner {
+	return &grpcScanner{
+		plaintext: &http.Client{Transport: &http2.Transport{
+			// "h2c", i.e. HTTP/2 without TLS
+			AllowHTTP: true,
+			DialTLS: func(network string, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}},
+		tls: &http.Client{Transport: &http2.Transport{}},
+	}
+}
+
+func (g *grpcScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	client, scheme := g.plaintext, "http"
+	if monitor.Tls {
+		client, scheme = g.tls, "https"
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		scheme+"://"+monitor.Target+"/grpc.health.v1.Health/Check",
+		bytes.NewReader(grpcFrame(grpcHealthCheckRequest(monitor.GrpcService))))
+	if err != nil {
+		return scanResult{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return scanResult{}, err
+	}
+	defer resp.Body.Close()
+
+	result := scanResult{statusCode: resp.StatusCode}
+
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body) // trailers are available only after reading body
+	if err != nil {
+		return result, err
+	}
+
+	if err := grpcStatusToError(resp); err != nil {
+		return result, err
+	}
+
+	message, err := grpcUnframe(body)
+	if err != nil {
+		return result, err
+	}
+
+	status := grpcHealthCheckResponseStatus(message)
+	if status != grpcServing {
+		return result, fmt.Errorf("health status %s", grpcServingStatuses[status])
+	}
+
+	return result, nil
+}
+
+// grpc-status is in trailers, except for "trailers-only" (= error) responses where it's in headers
+func grpcStatusToError(resp *http.Response) error {
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+
+	switch status {
+	case "0":
+		return nil
+	case "":
+		return errors.New("response missing grpc-status")
+	default:
+		return fmt.Errorf("grpc-status %s: %s", status, message)
+	}
+}
+
+// HealthCheckRequest{ string service = 1; }
+func grpcHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return []byte{} // default value => field omitted
+	}
+
+	msg := []byte{0x0a} // field 1, wire type 2 (length-delimited)
+	msg = appendUvarint(msg, uint64(len(service)))
+	return append(msg, service...)
+}
+
+// HealthCheckResponse{ ServingStatus status = 1; }. returns 0 (UNKNOWN) if not present
+func grpcHealthCheckResponseStatus(msg []byte) uint64 {
+	status := uint64(0)
+
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		if tag&0x07 != 0 { // only varint fields are expected
+			return 0
+		}
+
+		value, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		if tag>>3 == 1 {
+			status = value
+		}
+	}
+
+	return status
+}
+
+// gRPC message framing: compressed flag (1 byte) + message length (4 bytes) + message
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func grpcUnframe(frame []byte) ([]byte, error) {
+	if len(frame) < 5 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if frame[0] != 0 {
+		return nil, errors.New("compressed gRPC messages not supported")
+	}
+
+	length := binary.BigEndian.Uint32(frame[1:5])
+	if uint32(len(frame)-5) < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return frame[5 : 5+length], nil
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	varint := make([]byte, binary.MaxVarintLen64)
+	return append(buf, varint[:binary.PutUvarint(varint, value)]...)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/ezhttp"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type httpsAuditScanner struct {
+	client *http.Client
+	roots  *x509.CertPool // nil = system's
+}
+
+func newHttpsAuditScanner(opts scannerOptions) *httpsAuditScanner {
+	client := newHttpScanner(opts).noRedirects
+
+	transport := client.Transport.(*http.Transport).Clone()
+	// we verify the certificate ourselves so that a bad one is reported along with other
+	// violations (instead of failing the connection). old versions are allowed so we can
+	// report them as well.
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	}
+	client.Transport = transport
+
+	return &httpsAuditScanner{client: client}
+}
+
+func (s *httpsAuditScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	policy := monitor.GetAuditPolicy()
+
+	resp, err := ezhttp.Get(
+		ctx,
+		monitor.Target,
+		ezhttp.TolerateNon2xxResponse,
+		ezhttp.Client(s.client))
+	if err != nil {
+		return scanResult{}, err
+	}
+	resp.Body.Close()
+
+	result := scanResult{
+		statusCode: resp.StatusCode,
+		headers:    resp.Header,
+		certExpiry: earliestCertExpiry(resp.TLS),
+	}
+
+	violations := []string{}
+	violation := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	for _, required := range policy.RequireHeaders {
+		name, value := parseRequiredHeader(required)
+
+		actual, present := resp.Header[http.CanonicalHeaderKey(name)]
+		switch {
+		case !present:
+			violation("missing header %s", name)
+		case !strings.Contains(strings.Join(actual, ", "), value):
+			violation("header %s: expected to contain `%s`; got `%s`", name, value, strings.Join(actual, ", "))
+		}
+	}
+
+	if resp.TLS == nil {
+		violation("not served over TLS")
+	} else {
+		for _, problem := range s.certificateProblems(resp.TLS, resp.Request.URL.Hostname(), policy.CertHostname) {
+			violation("certificate: %s", problem)
+		}
+
+		if policy.MinTlsVersion != "" {
+			minVersion := tlsVersions[policy.MinTlsVersion]
+
+			if resp.TLS.Version < minVersion {
+				violation("negotiated TLS %s (minimum is %s)", tlsVersionName(resp.TLS.Version), policy.MinTlsVersion)
+			} else if accepted := acceptsOlderTls(ctx, resp.Request.URL, minVersion); accepted != 0 {
+				violation("accepts TLS %s (minimum is %s)", tlsVersionName(accepted), policy.MinTlsVersion)
+			}
+		}
+	}
+
+	if policy.RedirectToHttps {
+		if problem := s.redirectToHttpsProblem(ctx, monitor.Target, policy.HttpUrl); problem != "" {
+			violation("%s", problem)
+		}
+	}
+
+	if len(violations) > 0 {
+		return result, fmt.Errorf("%d violation(s):\n- %s", len(violations), strings.Join(violations, "\n- "))
+	}
+
+	return result, nil
+}
+
+func (s *httpsAuditScanner) certificateProblems(state *tls.ConnectionState, hostname string, checkHostname bool) []string {
+	if len(state.PeerCertificates) == 0 {
+		return []string{"server sent none"}
+	}
+
+	problems := []string{}
+
+	leaf := state.PeerCertificates[0]
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// hostname is checked separately to not report mismatch twice
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         s.roots,
+		Intermediates: intermediates,
+	}); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if checkHostname {
+		if err := leaf.VerifyHostname(hostname); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	return problems
+}
+
+// "" if plain-http URL redirects to https://
+func (s *httpsAuditScanner) redirectToHttpsProblem(ctx context.Context, target string, httpUrl string) string {
+	if httpUrl == "" {
+		var err error
+		httpUrl, err = plainHttpUrl(target)
+		if err != nil {
+			return err.Error()
+		}
+	}
+
+	resp, err := ezhttp.Get(
+		ctx,
+		httpUrl,
+		ezhttp.TolerateNon2xxResponse,
+		ezhttp.Client(s.client))
+	if err != nil {
+		return fmt.Sprintf("%s: %v", httpUrl, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return fmt.Sprintf("%s: expected redirect to https; got status %d", httpUrl, resp.StatusCode)
+	}
+
+	if location := resp.Header.Get("Location"); !strings.HasPrefix(location, "https://") {
+		return fmt.Sprintf("%s: redirects to non-https `%s`", httpUrl, location)
+	}
+
+	return ""
+}
+
+// "https://example.com:8443/foo" => "http://example.com/foo"
+func plainHttpUrl(target string) (string, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	parsed.Scheme = "http"
+	parsed.Host = parsed.Hostname()
+	if strings.Contains(parsed.Host, ":") { // IPv6
+		parsed.Host = "[" + parsed.Host + "]"
+	}
+
+	return parsed.String(), nil
+}
+
+// returns newest TLS version older than minVersion that the server accepts, or 0 if none
+func acceptsOlderTls(ctx context.Context, target *url.URL, minVersion uint16) uint16 {
+	if minVersion <= tls.VersionTLS10 {
+		return 0
+	}
+
+	host := target.Host
+	if target.Port() == "" {
+		host = net.JoinHostPort(target.Hostname(), "443")
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return 0 // main request connected fine, so this is probably transient. don't report
+	}
+	defer conn.Close()
+
+	if deadline, has := ctx.Deadline(); has {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0
+		}
+	} else if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return 0
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         target.Hostname(),
+		InsecureSkipVerify: true, // only interested in the version
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         minVersion - 1,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return 0
+	}
+
+	return tlsConn.ConnectionState().Version
+}
+
+// "Name: value" => ("Name", "value")
+func parseRequiredHeader(required string) (string, string) {
+	pos := strings.Index(required, ":")
+	if pos == -1 {
+		return strings.TrimSpace(required), ""
+	}
+
+	return strings.TrimSpace(required[:pos]), strings.TrimSpace(required[pos+1:])
+}
+
+func tlsVersionName(version uint16) string {
+	for name, candidate := range tlsVersions {
+		if candidate == version {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// human readable
+func describeAuditPolicy(policy amdomain.HttpsAuditPolicy) string {
+	items := []string{}
+	for _, required := range policy.RequireHeaders {
+		name, _ := parseRequiredHeader(required)
+		items = append(items, name)
+	}
+	if policy.RedirectToHttps {
+		items = append(items, "https redirect")
+	}
+	if policy.MinTlsVersion != "" {
+		items = append(items, "TLS >= "+policy.MinTlsVersion)
+	}
+	if policy.CertHostname {
+		items = append(items, "cert hostname")
+	}
+
+	return strings.Join(items, ", ")
+}
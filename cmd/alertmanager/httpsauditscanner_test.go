@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestHttpsAuditScanner(t *testing.T) {
+	site := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		if r.URL.Path == "/secure" {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		}
+		fmt.Fprintln(w, "Welcome")
+	}))
+	defer site.Close()
+
+	redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, site.URL+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer redirecting.Close()
+
+	notRedirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Welcome (insecurely)")
+	}))
+	defer notRedirecting.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(site.Certificate())
+
+	scanner := newHttpsAuditScanner(defaultScannerOptions())
+	scanner.roots = roots
+
+	audit := func(target string, policy amdomain.HttpsAuditPolicy) string {
+		_, err := scanner.Scan(context.Background(), amstate.Monitor{MonitorConfig: amdomain.MonitorConfig{
+			Kind:   amdomain.MonitorKindHttpsAudit,
+			Target: target,
+			Audit:  &policy,
+		}})
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+
+	// httptest server doesn't accept < 1.2
+	assert.EqualString(t, audit(site.URL+"/secure", amdomain.HttpsAuditPolicy{
+		RequireHeaders:  []string{"Strict-Transport-Security: max-age=", "x-content-type-options"},
+		RedirectToHttps: true,
+		HttpUrl:         redirecting.URL + "/secure",
+		MinTlsVersion:   "1.2",
+		CertHostname:    true,
+	}), "ok")
+
+	// all violations in one error
+	assert.EqualString(t, audit(site.URL+"/", amdomain.HttpsAuditPolicy{
+		RequireHeaders:  []string{"Strict-Transport-Security: includeSubDomains", "X-Content-Type-Options", "X-Frame-Options"},
+		RedirectToHttps: true,
+		HttpUrl:         notRedirecting.URL + "/",
+		MinTlsVersion:   "1.3",
+	}), fmt.Sprintf(`5 violation(s):
+- header Strict-Transport-Security: expected to contain `+"`includeSubDomains`; got `max-age=31536000`"+`
+- missing header X-Content-Type-Options
+- missing header X-Frame-Options
+- accepts TLS 1.2 (minimum is 1.3)
+- %s/: expected redirect to https; got status 200`, notRedirecting.URL))
+
+	// certificate is for 127.0.0.1 and example.com
+	withWrongHostname := strings.Replace(site.URL, "127.0.0.1", "localhost", 1)
+	assert.Assert(t, strings.HasPrefix(audit(withWrongHostname, amdomain.HttpsAuditPolicy{
+		CertHostname: true,
+	}), "1 violation(s):\n- certificate: x509: certificate is valid for "))
+
+	// not trusted (certificate is valid for hostname)
+	scanner.roots = nil
+	assert.Assert(t, strings.HasPrefix(audit(site.URL+"/", amdomain.HttpsAuditPolicy{
+		CertHostname: true,
+	}), "1 violation(s):\n- certificate: x509: certificate signed by unknown authority"))
+}
+
+func TestPlainHttpUrl(t *testing.T) {
+	plain := func(target string) string {
+		url, err := plainHttpUrl(target)
+		assert.Ok(t, err)
+		return url
+	}
+
+	assert.EqualString(t, plain("https://example.com/foo?bar=1"), "http://example.com/foo?bar=1")
+	assert.EqualString(t, plain("https://example.com:8443/"), "http://example.com/")
+	assert.EqualString(t, plain("https://[::1]:8443/"), "http://[::1]/")
+}
+
+func TestParseRequiredHeader(t *testing.T) {
+	name, value := parseRequiredHeader("Strict-Transport-Security: max-age=31536000")
+	assert.EqualString(t, name, "Strict-Transport-Security")
+	assert.EqualString(t, value, "max-age=31536000")
+
+	name, value = parseRequiredHeader("X-Frame-Options")
+	assert.EqualString(t, name, "X-Frame-Options")
+	assert.EqualString(t, value, "")
+}
+
+func TestValidateHttpsAudit(t *testing.T) {
+	validate := func(target string, policy *amdomain.HttpsAuditPolicy) string {
+		err := validateMonitorConfig(monitorConfigWithDefaults(amdomain.MonitorConfig{
+			Kind:   amdomain.MonitorKindHttpsAudit,
+			Target: target,
+			Audit:  policy,
+		}))
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+
+	assert.EqualString(t, validate("https://example.com/", nil), "ok")
+	assert.EqualString(t, validate("http://example.com/", nil), "https_audit target must be https:// URL; got http://example.com/")
+	assert.EqualString(t, validate("https://example.com/", &amdomain.HttpsAuditPolicy{MinTlsVersion: "1.4"}), "unsupported TLS version: 1.4 (1.0 - 1.3)")
+	assert.EqualString(t, validate("https://example.com/", &amdomain.HttpsAuditPolicy{RequireHeaders: []string{": foo"}}), "required header needs a name; got `: foo`")
+	assert.EqualString(t, validate("https://example.com/", &amdomain.HttpsAuditPolicy{}), "audit policy doesn't check anything")
+
+	assert.EqualString(t, validateMonitorConfig(monitorConfigWithDefaults(amdomain.MonitorConfig{
+		Kind:   amdomain.MonitorKindHttp,
+		Target: "https://example.com/",
+		Find:   "Welcome",
+		Audit:  &amdomain.HttpsAuditPolicy{CertHostname: true},
+	})).Error(), "audit policy is only supported by https_audit monitors")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/ezhttp"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+type httpScanner struct {
+	noRedirects *http.Client
+}
+
+func newHttpScanner(opts scannerOptions) *httpScanner {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// default is 2, which would make us re-dial when checking many URLs of a host concurrently
+	transport.MaxIdleConnsPerHost = opts.perHostLimit
+
+	return &httpScanner{
+		&http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse // do not follow redirects
+			},
+		},
+	}
+}
+
+func (s *httpScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	resp, err := ezhttp.Get(
+		ctx,
+		monitor.Target,
+		ezhttp.TolerateNon2xxResponse,
+		ezhttp.Client(s.noRedirects)) // rationale: no much else than how previous one worked
+	if err != nil {
+		return scanResult{}, err
+	}
+	defer resp.Body.Close()
+
+	search, err := searchBody(resp.Body, monitor.Find, monitor.NotFind)
+	if err != nil {
+		return scanResult{
+			statusCode: resp.StatusCode,
+			headers:    resp.Header,
+			certExpiry: earliestCertExpiry(resp.TLS),
+		}, err
+	}
+
+	result := scanResult{
+		statusCode:    resp.StatusCode,
+		headers:       resp.Header,
+		body:          search.bodyStart,
+		bodyTruncated: !search.complete || search.bytesRead > len(search.bodyStart),
+		certExpiry:    earliestCertExpiry(resp.TLS),
+	}
+
+	if err := mustHaveExpectedStatus(resp.StatusCode, monitor); err != nil {
+		return result, err
+	}
+
+	if monitor.Find != "" && !search.found[0] {
+		return result, search.notFoundError(monitor.Find)
+	}
+
+	if monitor.NotFind != "" && search.found[1] {
+		return result, fmt.Errorf("must-not-contain string `%s` found in body: %s", monitor.NotFind, search.excerpt())
+	}
+
+	return result, nil
+}
+
+// zero if not TLS
+func earliestCertExpiry(state *tls.ConnectionState) time.Time {
+	earliest := time.Time{}
+
+	if state == nil {
+		return earliest
+	}
+
+	for _, cert := range state.PeerCertificates {
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+
+	return earliest
+}
+
+func mustHaveExpectedStatus(statusCode int, monitor amstate.Monitor) error {
+	switch {
+	case monitor.ExpectStatus != 0:
+		if statusCode != monitor.ExpectStatus {
+			return fmt.Errorf("expected status %d; got %d", monitor.ExpectStatus, statusCode)
+		}
+	case monitor.Find == "": // no content to check => error pages must not pass as being up
+		if statusCode >= 400 {
+			return fmt.Errorf("got status %d", statusCode)
+		}
+	}
+
+	// with find-string given we've traditionally accepted any status
+
+	return nil
+}
+
+const (
+	maxBodyBytesToSearch = 2 * 1024 * 1024
+	bodyExcerptBytes     = 200       // shown in alert when string is not found
+	bodySnapshotBytes    = 16 * 1024 // kept for response snapshot of a failed check
+)
+
+func mustFindStringInBody(body io.Reader, find string) error {
+	search, err := searchBody(body, find)
+	if err != nil {
+		return err
+	}
+
+	if !search.found[0] {
+		return search.notFoundError(find)
+	}
+
+	return nil
+}
+
+type bodySearchResult struct {
+	found        []bool // indexes match needles'
+	bodyStart    []byte // up to bodySnapshotBytes
+	complete     bool   // read until EOF
+	bytesRead    int
+	limitReached bool
+}
+
+func (b *bodySearchResult) excerpt() string {
+	excerpt := b.bodyStart
+	if len(excerpt) > bodyExcerptBytes {
+		excerpt = excerpt[:bodyExcerptBytes]
+	}
+
+	return bodyExcerpt(excerpt, b.bytesRead)
+}
+
+func (b *bodySearchResult) notFoundError(find string) error {
+	if b.limitReached {
+		return fmt.Errorf(
+			"string-to-find `%s` NOT in first %d bytes of body: %s",
+			find,
+			maxBodyBytesToSearch,
+			b.excerpt())
+	}
+
+	return fmt.Errorf("string-to-find `%s` NOT in body: %s", find, b.excerpt())
+}
+
+// searches the body as it streams in, so we can stop reading as soon as we've found all the
+// strings and don't need to hold the whole body in memory. empty needles count as found.
+func searchBody(body io.Reader, needles ...string) (*bodySearchResult, error) {
+	result := &bodySearchResult{
+		found: make([]bool, len(needles)),
+	}
+
+	longestNeedle := 0
+	for idx, needle := range needles {
+		result.found[idx] = needle == ""
+
+		if len(needle) > longestNeedle {
+			longestNeedle = len(needle)
+		}
+	}
+
+	allFound := func() bool {
+		for _, found := range result.found {
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	window := []byte{} // previous chunk's tail (needle might straddle chunks) + current chunk
+	buf := make([]byte, 32*1024)
+
+	// also read enough for the snapshot, which is cheap compared to the request itself
+	for !allFound() || len(result.bodyStart) < bodySnapshotBytes {
+		if result.bytesRead >= maxBodyBytesToSearch {
+			result.limitReached = true
+			break
+		}
+
+		toRead := buf
+		if remaining := maxBodyBytesToSearch - result.bytesRead; remaining < len(toRead) {
+			toRead = toRead[:remaining]
+		}
+
+		n, err := body.Read(toRead)
+		if n > 0 {
+			chunk := toRead[:n]
+			result.bytesRead += n
+
+			if missing := bodySnapshotBytes - len(result.bodyStart); missing > 0 {
+				if missing > n {
+					missing = n
+				}
+				result.bodyStart = append(result.bodyStart, chunk[:missing]...)
+			}
+
+			window = append(window, chunk...)
+			for idx, needle := range needles {
+				if !result.found[idx] && bytes.Contains(window, []byte(needle)) {
+					result.found[idx] = true
+				}
+			}
+
+			// retain only what could be the beginning of a match
+			if keep := longestNeedle - 1; keep >= 0 && len(window) > keep {
+				window = append(window[:0], window[len(window)-keep:]...)
+			}
+		}
+
+		if err == io.EOF {
+			result.complete = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// single line, and marked as truncated if body was longer than the excerpt
+func bodyExcerpt(excerpt []byte, bodyLen int) string {
+	// cutting might have left half of a multi-byte character at the end
+	singleLine := strings.Join(strings.Fields(strings.ToValidUTF8(string(excerpt), "")), " ")
+
+	if bodyLen > len(excerpt) {
+		return singleLine + " [...]"
+	}
+
+	return singleLine
+}
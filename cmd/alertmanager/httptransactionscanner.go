@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+// "{{token}}"
+var stepVariableRe = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+type httpTransactionScanner struct {
+	transport http.RoundTripper
+}
+
+func newHttpTransactionScanner(opts scannerOptions) *httpTransactionScanner {
+	return &httpTransactionScanner{
+		transport: newHttpScanner(opts).noRedirects.Transport,
+	}
+}
+
+func (s *httpTransactionScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	// each run starts from a clean slate, like a new browser session would
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return scanResult{}, err
+	}
+
+	client := &http.Client{
+		Transport: s.transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // redirects are steps' business (e.g. assert or capture Location)
+		},
+	}
+
+	captured := map[string]string{}
+
+	result := scanResult{}
+
+	for idx, step := range monitor.Steps {
+		result.statusCode, err = runHttpStep(ctx, client, step, captured)
+		if err != nil {
+			return result, fmt.Errorf("step %d (%s): %w", idx+1, httpStepName(step), err)
+		}
+	}
+
+	return result, nil
+}
+
+func runHttpStep(
+	ctx context.Context,
+	client *http.Client,
+	step amdomain.HttpStep,
+	captured map[string]string,
+) (int, error) {
+	url, err := expandStepVariables(step.Url, captured)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := expandStepVariables(step.Body, captured)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, httpStepMethod(step), url, strings.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	for key, value := range step.Headers {
+		expanded, err := expandStepVariables(value, captured)
+		if err != nil {
+			return 0, err
+		}
+
+		req.Header.Set(key, expanded)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyBytesToSearch))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if step.ExpectStatus != 0 && resp.StatusCode != step.ExpectStatus {
+		return resp.StatusCode, fmt.Errorf("expected status %d; got %d", step.ExpectStatus, resp.StatusCode)
+	}
+	if step.ExpectStatus == 0 && resp.StatusCode >= 400 {
+		return resp.StatusCode, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+
+	for key, expected := range step.ExpectHeaders {
+		if actual := resp.Header.Get(key); !strings.Contains(actual, expected) {
+			return resp.StatusCode, fmt.Errorf("header %s: expected to contain `%s`; got `%s`", key, expected, actual)
+		}
+	}
+
+	if step.Find != "" {
+		if err := mustFindStringInBody(bytes.NewReader(respBody), step.Find); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+
+	for _, capture := range step.Captures {
+		value, err := captureFromResponse(capture, resp.Header, respBody)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("capture %s: %w", capture.Name, err)
+		}
+
+		captured[capture.Name] = value
+	}
+
+	return resp.StatusCode, nil
+}
+
+func captureFromResponse(capture amdomain.HttpCapture, headers http.Header, body []byte) (string, error) {
+	switch {
+	case capture.Header != "":
+		value := headers.Get(capture.Header)
+		if value == "" {
+			return "", fmt.Errorf("no header %s", capture.Header)
+		}
+
+		return value, nil
+	case capture.JsonPath != "":
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", fmt.Errorf("body not JSON: %v", err)
+		}
+
+		return lookupJsonPath(doc, capture.JsonPath)
+	case capture.Regex != "":
+		re, err := regexp.Compile(capture.Regex)
+		if err != nil {
+			return "", err
+		}
+
+		match := re.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("no match for `%s` in body: %s", capture.Regex, bodyExcerpt(excerptOf(body), len(body)))
+		}
+
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+
+		return string(match[0]), nil
+	default:
+		return "", errors.New("no header, json_path or regex")
+	}
+}
+
+// "data.items.0.id" => doc["data"]["items"][0]["id"]
+func lookupJsonPath(doc interface{}, path string) (string, error) {
+	current := doc
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, found := node[key]
+			if !found {
+				return "", fmt.Errorf("%s: no key %s", path, key)
+			}
+			current = value
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return "", fmt.Errorf("%s: no index %s", path, key)
+			}
+			current = node[idx]
+		default:
+			return "", fmt.Errorf("%s: cannot descend into %s", path, key)
+		}
+	}
+
+	switch value := current.(type) {
+	case string:
+		return value, nil
+	case float64, bool:
+		return fmt.Sprintf("%v", value), nil
+	default:
+		return "", fmt.Errorf("%s: not a string, number or boolean", path)
+	}
+}
+
+func expandStepVariables(template string, captured map[string]string) (string, error) {
+	var missing error
+
+	expanded := stepVariableRe.ReplaceAllStringFunc(template, func(ref string) string {
+		name := stepVariableRe.FindStringSubmatch(ref)[1]
+
+		value, found := captured[name]
+		if !found {
+			missing = fmt.Errorf("variable %s not captured by previous steps", name)
+		}
+
+		return value
+	})
+
+	return expanded, missing
+}
+
+func httpStepMethod(step amdomain.HttpStep) string {
+	if step.Method == "" {
+		return http.MethodGet
+	}
+
+	return strings.ToUpper(step.Method)
+}
+
+func httpStepName(step amdomain.HttpStep) string {
+	if step.Name != "" {
+		return step.Name
+	}
+
+	return httpStepMethod(step) + " " + step.Url
+}
+
+func excerptOf(body []byte) []byte {
+	if len(body) > bodyExcerptBytes {
+		return body[:bodyExcerptBytes]
+	}
+
+	return body
+}
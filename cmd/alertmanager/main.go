@@ -34,7 +34,9 @@ func main() {
 
 	app.AddCommand(deadMansSwitchEntry())
 
-	app.AddCommand(httpMonitorEntry())
+	app.AddCommand(monitorEntry())
+
+	app.AddCommand(agentEntry())
 
 	app.AddCommand(ehcli.Entrypoint())
 
@@ -54,7 +56,8 @@ func main() {
 }
 
 func getApp(ctx context.Context) (*amstate.App, error) {
-	tenantCtx, err := ehreader.TenantCtxWithSnapshotsFrom(ehreader.ConfigFromEnv, "am:v1")
+	// bump version when state format changes in incompatible way (snapshots get rebuilt from events)
+	tenantCtx, err := ehreader.TenantCtxWithSnapshotsFrom(ehreader.ConfigFromEnv, "am:v2")
 	if err != nil {
 		return nil, err
 	}
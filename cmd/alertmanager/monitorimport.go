@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/ezhttp"
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/spf13/cobra"
+)
+
+// sitemap indexes only point to sitemaps, but let's not trust that
+const maxSitemapDepth = 3
+
+func monitorImportEntry() *cobra.Command {
+	config, _ := defaultMonitorConfig()
+	sitemap := ""
+	urlList := ""
+	include := ""
+	exclude := ""
+	dryRun := false
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Create HTTP monitors for URLs in a sitemap or URL list (skips already monitored URLs)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorImport(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				sitemap,
+				urlList,
+				include,
+				exclude,
+				config,
+				dryRun))
+		},
+	}
+
+	cmd.Flags().StringVarP(&sitemap, "sitemap", "", sitemap, "URL or path of sitemap.xml (or sitemap index)")
+	cmd.Flags().StringVarP(&urlList, "url-list", "", urlList, "URL or path of a file with one URL per line")
+	cmd.Flags().StringVarP(&config.Find, "find", "", config.Find, "String to find (same for all pages)")
+	cmd.Flags().StringVarP(&include, "include", "", include, "Only import URLs matching this regex")
+	cmd.Flags().StringVarP(&exclude, "exclude", "", exclude, "Skip URLs matching this regex")
+	cmd.Flags().DurationVarP(&config.Interval, "interval", "i", config.Interval, "Check interval (1m, 5m, 15m or 1h)")
+	cmd.Flags().DurationVarP(&config.Timeout, "timeout", "t", config.Timeout, "Timeout for one check (including retry)")
+	cmd.Flags().IntVarP(&config.AlertAfterFailures, "alert-after", "", config.AlertAfterFailures, "Alert only after N consecutive failed runs")
+	cmd.Flags().IntVarP(&config.RecoverAfterSuccesses, "recover-after", "", config.RecoverAfterSuccesses, "Ack alert after M consecutive successful runs (0 = ack manually)")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", dryRun, "Only print what would be created")
+
+	return cmd
+}
+
+func monitorImport(
+	ctx context.Context,
+	sitemap string,
+	urlList string,
+	include string,
+	exclude string,
+	template amdomain.MonitorConfig,
+	dryRun bool,
+) error {
+	filter, err := newUrlFilter(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	var urls []string
+	switch {
+	case sitemap != "" && urlList == "":
+		urls, err = sitemapUrls(ctx, sitemap)
+	case urlList != "" && sitemap == "":
+		urls, err = urlListUrls(ctx, urlList)
+	default:
+		return errors.New("specify either --sitemap or --url-list")
+	}
+	if err != nil {
+		return err
+	}
+
+	urls = filter.filter(urls)
+
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return transactPlan(ctx, app, dryRun, "Nothing to import", os.Stdout, func(now time.Time) (*applyPlan, error) {
+		return planMonitorImport(urls, template, app.State.Monitors(), now)
+	})
+}
+
+func planMonitorImport(
+	urls []string,
+	template amdomain.MonitorConfig,
+	existing []amstate.Monitor,
+	now time.Time,
+) (*applyPlan, error) {
+	plan := &applyPlan{}
+
+	seen := map[string]bool{}
+	skipped := 0
+
+	for _, url := range urls {
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+
+		config := template
+		config.Kind = amdomain.MonitorKindHttp
+		config.Target = url
+
+		if err := validateMonitorConfig(config); err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+
+		if amstate.FindMonitorWithSubject(amstate.Monitor{MonitorConfig: config}.Subject(), existing) != nil {
+			skipped++
+			continue
+		}
+
+		plan.add(
+			fmt.Sprintf("+ create %s", url),
+			amdomain.NewMonitorCreated(
+				amstate.NewMonitorId(),
+				true,
+				config,
+				ehevent.MetaSystemUser(now)))
+	}
+
+	if skipped > 0 {
+		plan.lines = append(plan.lines, fmt.Sprintf("  skipped %d already monitored URL(s)", skipped))
+	}
+
+	return plan, nil
+}
+
+type urlFilter struct {
+	include *regexp.Regexp // nil = include all
+	exclude *regexp.Regexp // nil = exclude none
+}
+
+func newUrlFilter(include string, exclude string) (*urlFilter, error) {
+	filter := &urlFilter{}
+
+	var err error
+	if include != "" {
+		if filter.include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+	}
+	if exclude != "" {
+		if filter.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("exclude: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+func (u *urlFilter) filter(urls []string) []string {
+	filtered := []string{}
+
+	for _, url := range urls {
+		if u.include != nil && !u.include.MatchString(url) {
+			continue
+		}
+		if u.exclude != nil && u.exclude.MatchString(url) {
+			continue
+		}
+
+		filtered = append(filtered, url)
+	}
+
+	return filtered
+}
+
+// https://www.sitemaps.org/protocol.html
+type sitemapXml struct {
+	XMLName  xml.Name
+	Urls     []sitemapLoc `xml:"url"`     // <urlset>
+	Sitemaps []sitemapLoc `xml:"sitemap"` // <sitemapindex>
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// follows sitemap indexes
+func sitemapUrls(ctx context.Context, location string) ([]string, error) {
+	urls := []string{}
+	visited := map[string]bool{}
+
+	var visit func(location string, depth int) error
+	visit = func(location string, depth int) error {
+		if visited[location] {
+			return nil
+		}
+		visited[location] = true
+
+		if depth > maxSitemapDepth {
+			return fmt.Errorf("sitemaps nested too deep at %s", location)
+		}
+
+		content, err := fetchUrlOrFile(ctx, location)
+		if err != nil {
+			return err
+		}
+
+		sitemap := sitemapXml{}
+		if err := xml.Unmarshal(content, &sitemap); err != nil {
+			return fmt.Errorf("%s: %w", location, err)
+		}
+
+		switch sitemap.XMLName.Local {
+		case "urlset":
+			for _, url := range sitemap.Urls {
+				urls = append(urls, strings.TrimSpace(url.Loc))
+			}
+		case "sitemapindex":
+			for _, child := range sitemap.Sitemaps {
+				if err := visit(strings.TrimSpace(child.Loc), depth+1); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%s: not a sitemap or sitemap index: <%s>", location, sitemap.XMLName.Local)
+		}
+
+		return nil
+	}
+
+	return urls, visit(location, 1)
+}
+
+// one URL per line. empty lines and lines starting with # are ignored
+func urlListUrls(ctx context.Context, location string) ([]string, error) {
+	content, err := fetchUrlOrFile(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []string{}
+
+	lines := bufio.NewScanner(bytes.NewReader(content))
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	return urls, lines.Err()
+}
+
+// sitemap max size is 50 MB (uncompressed)
+const maxImportSourceSize = 50 * 1024 * 1024
+
+// gzipped content (like sitemap.xml.gz) is transparently decompressed
+func fetchUrlOrFile(ctx context.Context, location string) ([]byte, error) {
+	var content []byte
+	var err error
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		content, err = fetchUrl(ctx, location)
+	} else {
+		content, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) { // gzip magic
+		return gunzip(content, maxImportSourceSize)
+	}
+
+	return content, nil
+}
+
+// errors if decompressed size exceeds maxSize (a small file can decompress to gigabytes)
+func gunzip(content []byte, maxSize int64) ([]byte, error) {
+	gunzipped, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := readAllMax(gunzipped, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("decompressed %w", err)
+	}
+
+	return decompressed, nil
+}
+
+func fetchUrl(ctx context.Context, url string) ([]byte, error) {
+	resp, err := ezhttp.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := readAllMax(resp.Body, maxImportSourceSize)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+
+	return content, nil
+}
+
+// errors if there's more than maxSize (truncated content would give us a partial URL list)
+func readAllMax(r io.Reader, maxSize int64) ([]byte, error) {
+	// one more than allowed so we can tell if it was exceeded
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("size exceeds %d bytes", maxSize)
+	}
+
+	return content, nil
+}
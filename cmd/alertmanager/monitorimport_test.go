@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestSitemapUrls(t *testing.T) {
+	ctx := context.Background()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>` + server.URL + `/sitemap-pages.xml</loc></sitemap>
+	<sitemap><loc>` + server.URL + `/sitemap-blog.xml.gz</loc></sitemap>
+</sitemapindex>`))
+		case "/sitemap-pages.xml":
+			w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://example.com/</loc></url>
+	<url><loc> https://example.com/about </loc></url>
+</urlset>`))
+		case "/sitemap-blog.xml.gz":
+			gzipped := &bytes.Buffer{}
+			gzipWriter := gzip.NewWriter(gzipped)
+			_, _ = gzipWriter.Write([]byte(`<urlset><url><loc>https://example.com/blog/hello</loc></url></urlset>`))
+			assert.Ok(t, gzipWriter.Close())
+			w.Write(gzipped.Bytes())
+		case "/urls.txt":
+			w.Write([]byte("# comment\nhttps://example.com/\n\n  https://example.com/pricing\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	urls, err := sitemapUrls(ctx, server.URL+"/sitemap.xml")
+	assert.Ok(t, err)
+	assert.EqualString(t, strings.Join(urls, " "), "https://example.com/ https://example.com/about https://example.com/blog/hello")
+
+	urls, err = urlListUrls(ctx, server.URL+"/urls.txt")
+	assert.Ok(t, err)
+	assert.EqualString(t, strings.Join(urls, " "), "https://example.com/ https://example.com/pricing")
+
+	_, err = sitemapUrls(ctx, server.URL+"/urls.txt")
+	assert.Assert(t, err != nil)
+}
+
+func TestGunzip(t *testing.T) {
+	gzipped := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(gzipped)
+	_, _ = gzipWriter.Write([]byte(strings.Repeat("x", 1000)))
+	assert.Ok(t, gzipWriter.Close())
+
+	content, err := gunzip(gzipped.Bytes(), 1000)
+	assert.Ok(t, err)
+	assert.Assert(t, len(content) == 1000)
+
+	// gzip bomb
+	_, err = gunzip(gzipped.Bytes(), 999)
+	assert.EqualString(t, err.Error(), "decompressed size exceeds 999 bytes")
+}
+
+func TestReadAllMax(t *testing.T) {
+	content, err := readAllMax(strings.NewReader("12345"), 5)
+	assert.Ok(t, err)
+	assert.EqualString(t, string(content), "12345")
+
+	_, err = readAllMax(strings.NewReader("123456"), 5)
+	assert.EqualString(t, err.Error(), "size exceeds 5 bytes")
+}
+
+func TestPlanMonitorImport(t *testing.T) {
+	filter, err := newUrlFilter(`^https://example\.com/`, `/blog/`)
+	assert.Ok(t, err)
+
+	urls := filter.filter([]string{
+		"https://example.com/",
+		"https://example.com/about",
+		"https://example.com/about", // duplicate
+		"https://example.com/blog/hello",
+		"https://other.example.com/",
+	})
+
+	template, _ := defaultMonitorConfig()
+	template.Find = "</html>"
+
+	existing := []amstate.Monitor{httpMonitor("https://example.com/", "Welcome")}
+
+	plan, err := planMonitorImport(urls, template, existing, t0)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, strings.Join(plan.lines, "\n"), `+ create https://example.com/about
+  skipped 1 already monitored URL(s)`)
+	assert.Assert(t, len(plan.events) == 1)
+
+	template.Find = ""
+	_, err = planMonitorImport(urls, template, existing, t0)
+	assert.EqualString(t, err.Error(), "https://example.com/: http monitor needs string to find")
+
+	_, err = newUrlFilter("(", "")
+	assert.Assert(t, err != nil)
+}
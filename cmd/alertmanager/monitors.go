@@ -0,0 +1,876 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/gokit/stringutils"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+func monitorEntry() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "mon",
+		Aliases: []string{"hm"}, // from when we only had HTTP monitors
+		Short:   "Manage monitors (HTTP, TCP, DNS, gRPC, HTTP transactions, HTTPS audits, domain expiry)",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls",
+		Short: "List monitors",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorList(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil)))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm [id]",
+		Short: "Remove monitor",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorDelete(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0]))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "enable [id]",
+		Short: "Enable disabled monitor",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorEnableOrDisable(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0],
+				true))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "disable [id]",
+		Short: "Temporarily disable a monitor",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorEnableOrDisable(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0],
+				false))
+		},
+	})
+
+	config, kind := defaultMonitorConfig()
+
+	mk := &cobra.Command{
+		Use:   "mk [target] [find]",
+		Short: "Create monitor (target is URL for http & https_audit, host:port for tcp & grpc, hostname for dns, URL or hostname for domain_expiry)",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorCreate(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				monitorConfigFromArgs(config, kind, args)))
+		},
+	}
+
+	monitorConfigFlags(mk, &config, &kind)
+
+	cmd.AddCommand(mk)
+
+	cmd.AddCommand(monitorEditEntry())
+
+	cmd.AddCommand(monitorTestEntry())
+
+	cmd.AddCommand(monitorImportEntry())
+
+	cmd.AddCommand(monitorSnapshotEntry())
+
+	cmd.AddCommand(applyEntry(
+		"apply [file]",
+		"Make monitors match the ones declared in a YAML or JSON file",
+		planMonitors))
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stats [id]",
+		Short: "Show uptime and latency statistics of a monitor",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(monitorStatsPrint(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0]))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "scan",
+		Short: "Runs all enabled monitors (except agents') and raises alerts if appropriate",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := ossignal.InterruptOrTerminateBackgroundCtx(nil)
+
+			app, err := getApp(ctx)
+			exitIfError(err)
+
+			exitIfError(monitorScanAndAlertFailures(
+				ctx,
+				amstate.EnabledMonitors(amstate.MonitorsCheckedBy("", app.State.Monitors())),
+				app,
+				time.Now()))
+		},
+	})
+
+	return cmd
+}
+
+// for commands that take the monitor config as args & flags
+func defaultMonitorConfig() (amdomain.MonitorConfig, string) {
+	config := amdomain.MonitorConfig{
+		Kind:               amdomain.MonitorKindHttp,
+		Interval:           amstate.DefaultMonitorInterval,
+		Timeout:            amstate.DefaultMonitorTimeout,
+		AlertAfterFailures: 1,
+	}
+
+	return config, string(config.Kind)
+}
+
+func monitorConfigFlags(cmd *cobra.Command, config *amdomain.MonitorConfig, kind *string) {
+	cmd.Flags().StringVarP(kind, "kind", "k", *kind, "Monitor kind (http, tcp, dns, grpc, https_audit, domain_expiry). http_transaction monitors are created with apply")
+	cmd.Flags().StringVarP(&config.NotFind, "not-find", "", "", "String that must NOT be in body (http)")
+	cmd.Flags().IntVarP(&config.ExpectStatus, "expect-status", "", 0, "Exact status code to expect (http)")
+	cmd.Flags().BoolVarP(&config.ExpectUnreachable, "expect-unreachable", "", false, "Alert if the target is reachable (i.e. the check passes)")
+	cmd.Flags().StringVarP(&config.DnsRecordType, "record-type", "", "A", "DNS record type (A, AAAA, CNAME, MX, NS, TXT)")
+	cmd.Flags().StringSliceVarP(&config.Expect, "expect", "", nil, "DNS values that must be in the answer")
+	cmd.Flags().StringVarP(&config.GrpcService, "grpc-service", "", "", "gRPC service to check health of (empty = server's overall health)")
+	cmd.Flags().BoolVarP(&config.Tls, "tls", "", false, "Use TLS (tcp & grpc)")
+	cmd.Flags().IntVarP(&config.ExpiryAlertDays, "expiry-alert-days", "", 0, "Alert when domain expires in less than N days (domain_expiry). 0 = 30")
+	config.Audit = &amdomain.HttpsAuditPolicy{} // for flags. monitorConfigFromArgs() drops it if not used
+	auditPolicyFlags(cmd, config.Audit)
+	cmd.Flags().DurationVarP(&config.Interval, "interval", "i", config.Interval, "Check interval (1m, 5m, 15m or 1h)")
+	cmd.Flags().DurationVarP(&config.Timeout, "timeout", "t", config.Timeout, "Timeout for one check (including retry)")
+	cmd.Flags().IntVarP(&config.AlertAfterFailures, "alert-after", "", config.AlertAfterFailures, "Alert only after N consecutive failed runs")
+	cmd.Flags().IntVarP(&config.RecoverAfterSuccesses, "recover-after", "", config.RecoverAfterSuccesses, "Ack alert after M consecutive successful runs (0 = ack manually)")
+	cmd.Flags().StringSliceVarP(&config.Agents, "agent", "", nil, "Agent(s) that run the checks (for targets in private networks)")
+	cmd.Flags().IntVarP(&config.Quorum, "quorum", "", 0, "Alert only if this many locations see the monitor failing (0 = 1)")
+	cmd.Flags().DurationVarP(&config.QuorumWindow, "quorum-window", "", 0, "How recent failures count towards quorum (0 = two intervals)")
+}
+
+func auditPolicyFlags(cmd *cobra.Command, policy *amdomain.HttpsAuditPolicy) {
+	cmd.Flags().StringArrayVarP(&policy.RequireHeaders, "require-header", "", nil, "Header that must be present, or \"Name: value\" to require value (https_audit)")
+	cmd.Flags().BoolVarP(&policy.RedirectToHttps, "redirect-to-https", "", false, "Plain-http URL must redirect to https (https_audit)")
+	cmd.Flags().StringVarP(&policy.HttpUrl, "http-url", "", "", "Plain-http URL to check redirect of (https_audit). Default = target with http://")
+	cmd.Flags().StringVarP(&policy.MinTlsVersion, "min-tls", "", "", "Oldest TLS version the server may accept (1.0 - 1.3) (https_audit)")
+	cmd.Flags().BoolVarP(&policy.CertHostname, "cert-hostname", "", false, "Certificate must be valid for target's hostname (https_audit)")
+}
+
+// returns nil if no audit policy flags were given. otherwise current policy (or default policy,
+// if current is nil) with changes from flags.
+func changedAuditPolicy(
+	changed func(string) bool,
+	current *amdomain.HttpsAuditPolicy,
+	edited amdomain.HttpsAuditPolicy,
+) *amdomain.HttpsAuditPolicy {
+	if !changed("require-header") && !changed("redirect-to-https") && !changed("http-url") && !changed("min-tls") && !changed("cert-hostname") {
+		return nil
+	}
+
+	policy := amstate.DefaultHttpsAuditPolicy()
+	if current != nil {
+		policy = *current
+	}
+
+	if changed("require-header") {
+		policy.RequireHeaders = edited.RequireHeaders
+	}
+	if changed("redirect-to-https") {
+		policy.RedirectToHttps = edited.RedirectToHttps
+	}
+	if changed("http-url") {
+		policy.HttpUrl = edited.HttpUrl
+	}
+	if changed("min-tls") {
+		policy.MinTlsVersion = edited.MinTlsVersion
+	}
+	if changed("cert-hostname") {
+		policy.CertHostname = edited.CertHostname
+	}
+
+	return &policy
+}
+
+// args are [target] [find]
+func monitorConfigFromArgs(config amdomain.MonitorConfig, kind string, args []string) amdomain.MonitorConfig {
+	config.Kind = amdomain.MonitorKind(kind)
+	config.Target = args[0]
+	if len(args) > 1 {
+		config.Find = args[1]
+	}
+	if config.Kind != amdomain.MonitorKindDns { // has default value
+		config.DnsRecordType = ""
+	}
+	if config.Kind != amdomain.MonitorKindHttpsAudit || config.Audit == nil || reflect.DeepEqual(*config.Audit, amdomain.HttpsAuditPolicy{}) {
+		config.Audit = nil // default policy if none of the flags given
+	}
+
+	return config
+}
+
+func monitorEditEntry() *cobra.Command {
+	edited := amdomain.MonitorConfig{Audit: &amdomain.HttpsAuditPolicy{}}
+	url := ""
+
+	edit := &cobra.Command{
+		Use:   "edit [id]",
+		Short: "Change settings of a monitor (only given flags are changed)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			flags := cmd.Flags()
+
+			exitIfError(monitorEdit(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0],
+				func(config *amdomain.MonitorConfig) {
+					if flags.Changed("url") {
+						config.Target = url
+					}
+					if flags.Changed("target") {
+						config.Target = edited.Target
+					}
+					if flags.Changed("find") {
+						config.Find = edited.Find
+					}
+					if flags.Changed("not-find") {
+						config.NotFind = edited.NotFind
+					}
+					if flags.Changed("expect-status") {
+						config.ExpectStatus = edited.ExpectStatus
+					}
+					if flags.Changed("expect-unreachable") {
+						config.ExpectUnreachable = edited.ExpectUnreachable
+					}
+					if flags.Changed("record-type") {
+						config.DnsRecordType = edited.DnsRecordType
+					}
+					if flags.Changed("expect") {
+						config.Expect = edited.Expect
+					}
+					if flags.Changed("grpc-service") {
+						config.GrpcService = edited.GrpcService
+					}
+					if flags.Changed("tls") {
+						config.Tls = edited.Tls
+					}
+					if auditChanged := changedAuditPolicy(flags.Changed, config.Audit, *edited.Audit); auditChanged != nil {
+						config.Audit = auditChanged
+					}
+					if flags.Changed("expiry-alert-days") {
+						config.ExpiryAlertDays = edited.ExpiryAlertDays
+					}
+					if flags.Changed("interval") {
+						config.Interval = edited.Interval
+					}
+					if flags.Changed("timeout") {
+						config.Timeout = edited.Timeout
+					}
+					if flags.Changed("alert-after") {
+						config.AlertAfterFailures = edited.AlertAfterFailures
+					}
+					if flags.Changed("recover-after") {
+						config.RecoverAfterSuccesses = edited.RecoverAfterSuccesses
+					}
+					if flags.Changed("agent") {
+						config.Agents = edited.Agents
+					}
+					if flags.Changed("quorum") {
+						config.Quorum = edited.Quorum
+					}
+					if flags.Changed("quorum-window") {
+						config.QuorumWindow = edited.QuorumWindow
+					}
+				}))
+		},
+	}
+
+	edit.Flags().StringVarP(&edited.Target, "target", "", "", "Target (URL for http, host:port for tcp & grpc, hostname for dns)")
+	edit.Flags().StringVarP(&url, "url", "", "", "Same as --target")
+	edit.Flags().StringVarP(&edited.Find, "find", "", "", "String to find (http)")
+	edit.Flags().StringVarP(&edited.NotFind, "not-find", "", "", "String that must NOT be in body (http)")
+	edit.Flags().IntVarP(&edited.ExpectStatus, "expect-status", "", 0, "Exact status code to expect (http). 0 = any non-error status")
+	edit.Flags().BoolVarP(&edited.ExpectUnreachable, "expect-unreachable", "", false, "Alert if the target is reachable")
+	edit.Flags().StringVarP(&edited.DnsRecordType, "record-type", "", "", "DNS record type")
+	edit.Flags().StringSliceVarP(&edited.Expect, "expect", "", nil, "DNS values that must be in the answer")
+	edit.Flags().StringVarP(&edited.GrpcService, "grpc-service", "", "", "gRPC service to check health of")
+	edit.Flags().BoolVarP(&edited.Tls, "tls", "", false, "Use TLS (tcp & grpc)")
+	auditPolicyFlags(edit, edited.Audit)
+	edit.Flags().IntVarP(&edited.ExpiryAlertDays, "expiry-alert-days", "", 0, "Alert when domain expires in less than N days (domain_expiry)")
+	edit.Flags().DurationVarP(&edited.Interval, "interval", "i", 0, "Check interval (1m, 5m, 15m or 1h)")
+	edit.Flags().DurationVarP(&edited.Timeout, "timeout", "t", 0, "Timeout for one check (including retry)")
+	edit.Flags().IntVarP(&edited.AlertAfterFailures, "alert-after", "", 0, "Alert only after N consecutive failed runs")
+	edit.Flags().IntVarP(&edited.RecoverAfterSuccesses, "recover-after", "", 0, "Ack alert after M consecutive successful runs (0 = ack manually)")
+	edit.Flags().StringSliceVarP(&edited.Agents, "agent", "", nil, "Agent(s) that run the checks (empty = the scheduler)")
+	edit.Flags().IntVarP(&edited.Quorum, "quorum", "", 0, "Alert only if this many locations see the monitor failing (0 = 1)")
+	edit.Flags().DurationVarP(&edited.QuorumWindow, "quorum-window", "", 0, "How recent failures count towards quorum (0 = two intervals)")
+
+	return edit
+}
+
+func monitorList(ctx context.Context) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	view := termtables.CreateTable()
+	view.AddHeaders("Id", "Enabled", "Kind", "Target", "Expect", "Interval", "Timeout", "Agents", "Last checked", "Streak")
+
+	for _, monitor := range app.State.Monitors() {
+		lastChecked := "never"
+		if !monitor.LastChecked.IsZero() {
+			lastChecked = monitor.LastChecked.Format(time.RFC3339)
+		}
+
+		view.AddRow(
+			monitor.Id,
+			boolToCheckmark(monitor.Enabled),
+			monitor.Kind,
+			stringutils.Truncate(monitor.Target, 44),
+			stringutils.Truncate(describeExpectation(monitor.MonitorConfig), 30),
+			monitor.GetInterval().String(),
+			monitor.GetTimeout().String(),
+			strings.Join(monitor.Agents, ", "),
+			lastChecked,
+			streak(monitor))
+	}
+
+	fmt.Println(view.Render())
+
+	return nil
+}
+
+func monitorStatsPrint(ctx context.Context, id string) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if amstate.FindMonitorWithId(id, app.State.Monitors()) == nil {
+		return fmt.Errorf("monitor not found: %s", id)
+	}
+
+	view := termtables.CreateTable()
+	view.AddHeaders("Window", "Checks", "Failures", "Uptime", "p50", "p95")
+
+	for _, stats := range monitorStats(app.State.MonitorHistory(id), time.Now()) {
+		view.AddRow(
+			stats.Window,
+			stats.Checks,
+			stats.Failures,
+			fmt.Sprintf("%.3f %%", stats.Uptime),
+			fmt.Sprintf("%d ms", stats.LatencyP50Ms),
+			fmt.Sprintf("%d ms", stats.LatencyP95Ms))
+	}
+
+	fmt.Println(view.Render())
+
+	return nil
+}
+
+type monitorStatsOutput struct {
+	Window       string  `json:"window"`
+	Checks       int     `json:"checks"`
+	Failures     int     `json:"failures"`
+	Uptime       float64 `json:"uptime_percent"`
+	LatencyP50Ms int     `json:"latency_p50_ms"`
+	LatencyP95Ms int     `json:"latency_p95_ms"`
+}
+
+func monitorStats(history amstate.MonitorHistory, now time.Time) []monitorStatsOutput {
+	windows := []struct {
+		name   string
+		window time.Duration
+	}{
+		{"24h", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+	}
+
+	output := []monitorStatsOutput{}
+
+	for _, window := range windows {
+		stats := history.Stats(window.window, now)
+
+		output = append(output, monitorStatsOutput{
+			Window:       window.name,
+			Checks:       stats.Checks,
+			Failures:     stats.Failures,
+			Uptime:       stats.Uptime,
+			LatencyP50Ms: int(stats.LatencyP50.Milliseconds()),
+			LatencyP95Ms: int(stats.LatencyP95.Milliseconds()),
+		})
+	}
+
+	return output
+}
+
+func monitorCreate(ctx context.Context, config amdomain.MonitorConfig) error {
+	if err := validateMonitorConfig(config); err != nil {
+		return err
+	}
+
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := mustHaveAgents(config.Agents, app.State.Agents()); err != nil {
+		return err
+	}
+
+	monitorCreated := amdomain.NewMonitorCreated(
+		amstate.NewMonitorId(),
+		true,
+		config,
+		ehevent.MetaSystemUser(time.Now()))
+
+	ver := app.State.Version()
+
+	_, err = app.Writer.Append(ctx, ver.Stream(), []string{
+		ehevent.Serialize(monitorCreated),
+	})
+	return err
+}
+
+func monitorEdit(ctx context.Context, id string, edit func(config *amdomain.MonitorConfig)) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return monitorUpdate(ctx, app, id, edit)
+}
+
+var (
+	errMonitorNotFound  = errors.New("monitor not found")
+	errMonitorUnchanged = errors.New("monitor left unchanged")
+	errMonitorConflict  = errors.New("conflict")
+)
+
+// edit is called with a copy of the current config, and again with a fresh copy if our view
+// of the state was stale (someone else changed the monitor concurrently)
+func monitorUpdate(
+	ctx context.Context,
+	app *amstate.App,
+	id string,
+	edit func(config *amdomain.MonitorConfig),
+) error {
+	return app.Reader.TransactWrite(ctx, func() error {
+		monitorToEdit := amstate.FindMonitorWithId(id, app.State.Monitors())
+		if monitorToEdit == nil {
+			return fmt.Errorf("%w: %s", errMonitorNotFound, id)
+		}
+
+		config := monitorToEdit.MonitorConfig
+		config.Expect = append([]string(nil), config.Expect...) // so edit can't mutate state
+		edit(&config)
+		config = monitorConfigWithDefaults(config)
+
+		if err := validateMonitorConfig(config); err != nil {
+			return err
+		}
+
+		if reflect.DeepEqual(config, monitorConfigWithDefaults(monitorToEdit.MonitorConfig)) {
+			return fmt.Errorf("%w: %s", errMonitorUnchanged, id)
+		}
+
+		if err := mustNotDuplicateSubject(config, id, app.State.Monitors()); err != nil {
+			return err
+		}
+
+		if err := mustHaveAgents(config.Agents, app.State.Agents()); err != nil {
+			return err
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), amdomain.NewMonitorUpdated(
+			id,
+			config,
+			ehevent.MetaSystemUser(time.Now())))
+	})
+}
+
+// two monitors with same subject would get their alerts deduplicated as one
+func mustNotDuplicateSubject(config amdomain.MonitorConfig, id string, monitors []amstate.Monitor) error {
+	subject := amstate.Monitor{MonitorConfig: config}.Subject()
+
+	if other := amstate.FindMonitorWithSubject(subject, monitors); other != nil && other.Id != id {
+		return fmt.Errorf("%w: monitor %s already monitors %s", errMonitorConflict, other.Id, subject)
+	}
+
+	return nil
+}
+
+// monitor assigned to a nonexistent agent would never get checked (from that location)
+func mustHaveAgents(names []string, agents []amstate.Agent) error {
+	for _, name := range names {
+		if amstate.FindAgentWithName(name, agents) == nil {
+			return fmt.Errorf("agent not found: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// zero values mean defaults, but for validation and comparison we want them explicit
+func monitorConfigWithDefaults(config amdomain.MonitorConfig) amdomain.MonitorConfig {
+	withDefaults := amstate.Monitor{MonitorConfig: config}
+
+	config.Interval = withDefaults.GetInterval()
+	config.Timeout = withDefaults.GetTimeout()
+	config.AlertAfterFailures = withDefaults.GetAlertAfterFailures()
+	if len(config.Expect) == 0 { // nil and empty are same
+		config.Expect = nil
+	}
+
+	return config
+}
+
+func monitorDelete(ctx context.Context, id string) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return app.Reader.TransactWrite(ctx, func() error {
+		if amstate.FindMonitorWithId(id, app.State.Monitors()) == nil {
+			return fmt.Errorf("monitor to delete not found: %s", id)
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), amdomain.NewMonitorDeleted(
+			id,
+			ehevent.MetaSystemUser(time.Now())))
+	})
+}
+
+func monitorEnableOrDisable(ctx context.Context, id string, newState bool) error {
+	app, err := getApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return app.Reader.TransactWrite(ctx, func() error {
+		monitorToEdit := amstate.FindMonitorWithId(id, app.State.Monitors())
+		if monitorToEdit == nil {
+			return fmt.Errorf("monitor not found: %s", id)
+		}
+
+		if monitorToEdit.Enabled == newState {
+			return fmt.Errorf("monitor left unchanged: %s", id)
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), amdomain.NewMonitorEnabledUpdated(
+			id,
+			newState,
+			ehevent.MetaSystemUser(time.Now())))
+	})
+}
+
+func validateMonitorConfig(config amdomain.MonitorConfig) error {
+	if err := validateKindSpecific(config); err != nil {
+		return err
+	}
+
+	if err := validateIntervalAndTimeout(config.Interval, config.Timeout); err != nil {
+		return err
+	}
+
+	if err := validateThresholds(config.AlertAfterFailures, config.RecoverAfterSuccesses); err != nil {
+		return err
+	}
+
+	return validateQuorum(config)
+}
+
+func validateKindSpecific(config amdomain.MonitorConfig) error {
+	if config.Kind != amdomain.MonitorKindHttp && (config.NotFind != "" || config.ExpectStatus != 0) {
+		return errors.New("not-find and expect-status are only supported by http monitors")
+	}
+
+	if config.Kind != amdomain.MonitorKindHttpsAudit && config.Audit != nil {
+		return errors.New("audit policy is only supported by https_audit monitors")
+	}
+
+	if config.Kind != amdomain.MonitorKindDomainExpiry && config.ExpiryAlertDays != 0 {
+		return errors.New("expiry-alert-days is only supported by domain_expiry monitors")
+	}
+
+	switch config.Kind {
+	case amdomain.MonitorKindHttp:
+		if !strings.HasPrefix(config.Target, "http://") && !strings.HasPrefix(config.Target, "https://") {
+			return fmt.Errorf("http target must be http:// or https:// URL; got %s", config.Target)
+		}
+
+		// without any of these there'd be no way to tell a useful page from an error page
+		if config.Find == "" && config.NotFind == "" && config.ExpectStatus == 0 && !config.ExpectUnreachable {
+			return errors.New("http monitor needs string to find")
+		}
+
+		if config.ExpectStatus != 0 && (config.ExpectStatus < 100 || config.ExpectStatus > 599) {
+			return fmt.Errorf("expect-status must be a HTTP status code; got %d", config.ExpectStatus)
+		}
+	case amdomain.MonitorKindTcp, amdomain.MonitorKindGrpc:
+		if _, _, err := net.SplitHostPort(config.Target); err != nil {
+			return fmt.Errorf("%s target must be host:port: %v", config.Kind, err)
+		}
+	case amdomain.MonitorKindDns:
+		if config.Target == "" {
+			return errors.New("dns target must be a hostname")
+		}
+
+		if _, supported := dnsLookups[config.DnsRecordType]; !supported {
+			return fmt.Errorf("unsupported DNS record type: %s", config.DnsRecordType)
+		}
+	case amdomain.MonitorKindHttpTransaction:
+		if config.Target == "" {
+			return errors.New("http_transaction target must be a name for the transaction")
+		}
+
+		return validateHttpSteps(config.Steps)
+	case amdomain.MonitorKindHttpsAudit:
+		if !strings.HasPrefix(config.Target, "https://") {
+			return fmt.Errorf("https_audit target must be https:// URL; got %s", config.Target)
+		}
+
+		if config.Audit != nil {
+			return validateAuditPolicy(*config.Audit)
+		}
+	case amdomain.MonitorKindDomainExpiry:
+		if _, err := registrableDomain(config.Target); err != nil {
+			return fmt.Errorf("domain_expiry target must be URL or hostname: %w", err)
+		}
+
+		if config.ExpiryAlertDays < 0 {
+			return fmt.Errorf("expiry-alert-days cannot be negative; got %d", config.ExpiryAlertDays)
+		}
+	default:
+		return fmt.Errorf("unsupported monitor kind: %s", config.Kind)
+	}
+
+	return nil
+}
+
+func validateAuditPolicy(policy amdomain.HttpsAuditPolicy) error {
+	for _, required := range policy.RequireHeaders {
+		if name, _ := parseRequiredHeader(required); name == "" {
+			return fmt.Errorf("required header needs a name; got `%s`", required)
+		}
+	}
+
+	if policy.HttpUrl != "" && !strings.HasPrefix(policy.HttpUrl, "http://") {
+		return fmt.Errorf("http-url must be http:// URL; got %s", policy.HttpUrl)
+	}
+
+	if _, supported := tlsVersions[policy.MinTlsVersion]; policy.MinTlsVersion != "" && !supported {
+		return fmt.Errorf("unsupported TLS version: %s (1.0 - 1.3)", policy.MinTlsVersion)
+	}
+
+	if reflect.DeepEqual(policy, amdomain.HttpsAuditPolicy{}) {
+		return errors.New("audit policy doesn't check anything")
+	}
+
+	return nil
+}
+
+func validateHttpSteps(steps []amdomain.HttpStep) error {
+	if len(steps) == 0 {
+		return errors.New("http_transaction needs at least one step")
+	}
+
+	captured := map[string]bool{}
+
+	for idx, step := range steps {
+		stepErr := func(err error) error {
+			return fmt.Errorf("step %d (%s): %w", idx+1, httpStepName(step), err)
+		}
+
+		if !strings.HasPrefix(step.Url, "http://") && !strings.HasPrefix(step.Url, "https://") {
+			return stepErr(errors.New("url must be http:// or https:// URL"))
+		}
+
+		// can only refer to values captured by previous steps
+		templates := []string{step.Url, step.Body}
+		for _, value := range step.Headers {
+			templates = append(templates, value)
+		}
+		for _, template := range templates {
+			for _, ref := range stepVariableRe.FindAllStringSubmatch(template, -1) {
+				if !captured[ref[1]] {
+					return stepErr(fmt.Errorf("variable %s not captured by previous steps", ref[1]))
+				}
+			}
+		}
+
+		for _, capture := range step.Captures {
+			sources := 0
+			for _, source := range []string{capture.Header, capture.JsonPath, capture.Regex} {
+				if source != "" {
+					sources++
+				}
+			}
+
+			if capture.Name == "" || sources != 1 {
+				return stepErr(errors.New("capture needs name and exactly one of header, json_path or regex"))
+			}
+
+			if capture.Regex != "" {
+				if _, err := regexp.Compile(capture.Regex); err != nil {
+					return stepErr(err)
+				}
+			}
+
+			captured[capture.Name] = true
+		}
+	}
+
+	return nil
+}
+
+// human readable summary of what the monitor checks for
+func describeExpectation(config amdomain.MonitorConfig) string {
+	if config.ExpectUnreachable {
+		return "unreachable"
+	}
+
+	switch config.Kind {
+	case amdomain.MonitorKindHttp:
+		expectations := []string{}
+		if config.ExpectStatus != 0 {
+			expectations = append(expectations, fmt.Sprintf("status %d", config.ExpectStatus))
+		}
+		if config.Find != "" {
+			expectations = append(expectations, config.Find)
+		}
+		if config.NotFind != "" {
+			expectations = append(expectations, "NOT "+config.NotFind)
+		}
+		if len(expectations) == 0 {
+			return "status < 400"
+		}
+		return strings.Join(expectations, ", ")
+	case amdomain.MonitorKindTcp:
+		if config.Tls {
+			return "TLS handshake"
+		}
+		return "connect"
+	case amdomain.MonitorKindDns:
+		if len(config.Expect) == 0 {
+			return config.DnsRecordType + " resolves"
+		}
+		return config.DnsRecordType + " = " + strings.Join(config.Expect, ", ")
+	case amdomain.MonitorKindGrpc:
+		if config.GrpcService == "" {
+			return "SERVING"
+		}
+		return config.GrpcService + " SERVING"
+	case amdomain.MonitorKindHttpTransaction:
+		return fmt.Sprintf("%d steps", len(config.Steps))
+	case amdomain.MonitorKindHttpsAudit:
+		return describeAuditPolicy(amstate.Monitor{MonitorConfig: config}.GetAuditPolicy())
+	case amdomain.MonitorKindDomainExpiry:
+		return fmt.Sprintf(">= %d days left", amstate.Monitor{MonitorConfig: config}.GetExpiryAlertDays())
+	default:
+		return ""
+	}
+}
+
+// scheduler runs once a minute, so intervals need to be whole minutes. we keep the set small
+// so that monitors sharing an interval get checked on the same runs.
+var supportedIntervals = []time.Duration{
+	1 * time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	1 * time.Hour,
+}
+
+func validateIntervalAndTimeout(interval time.Duration, timeout time.Duration) error {
+	intervalSupported := false
+	for _, supported := range supportedIntervals {
+		if interval == supported {
+			intervalSupported = true
+		}
+	}
+
+	if !intervalSupported {
+		return fmt.Errorf("unsupported interval %s; supported: %v", interval, supportedIntervals)
+	}
+
+	// a check cannot take longer than one scheduler run
+	if timeout < 1*time.Second || timeout > 1*time.Minute {
+		return fmt.Errorf("timeout must be between 1s and 1m; got %s", timeout)
+	}
+
+	return nil
+}
+
+func validateThresholds(alertAfterFailures int, recoverAfterSuccesses int) error {
+	if alertAfterFailures < 1 {
+		return fmt.Errorf("alert-after must be at least 1; got %d", alertAfterFailures)
+	}
+
+	if recoverAfterSuccesses < 0 {
+		return fmt.Errorf("recover-after cannot be negative; got %d", recoverAfterSuccesses)
+	}
+
+	return nil
+}
+
+func validateQuorum(config amdomain.MonitorConfig) error {
+	if config.Quorum < 0 || config.QuorumWindow < 0 {
+		return errors.New("quorum and quorum-window cannot be negative")
+	}
+
+	if config.Quorum > 1 && config.AlertAfterFailures > 1 {
+		// consecutive failures are counted over results from all locations
+		return errors.New("quorum and alert-after cannot be combined")
+	}
+
+	// with the scheduler we can't know in how many regions it's deployed in
+	if len(config.Agents) > 0 && config.Quorum > len(config.Agents) {
+		return fmt.Errorf("quorum %d is more than the %d agent(s) checking the monitor", config.Quorum, len(config.Agents))
+	}
+
+	if config.QuorumWindow != 0 && config.QuorumWindow < config.Interval {
+		return fmt.Errorf("quorum-window must be at least the interval (%s)", config.Interval)
+	}
+
+	return nil
+}
+
+// "✗×3" = three consecutive failures
+func streak(monitor amstate.Monitor) string {
+	switch {
+	case monitor.ConsecutiveFailures > 0:
+		return fmt.Sprintf("%s×%d", boolToCheckmark(false), monitor.ConsecutiveFailures)
+	case monitor.ConsecutiveSuccesses > 0:
+		return fmt.Sprintf("%s×%d", boolToCheckmark(true), monitor.ConsecutiveSuccesses)
+	default:
+		return ""
+	}
+}
+
+func boolToCheckmark(input bool) string {
+	if input {
+		return "✓"
+	} else {
+		return "✗"
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/eventhorizon/pkg/ehreader"
+	"github.com/function61/eventhorizon/pkg/ehreader/ehreadertest"
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestMonitorUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	testStreamName := "/t-42/alertmanager"
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewMonitorCreated(
+			"m1",
+			true,
+			amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttp,
+				Target: "https://example.com/",
+				Find:   "Example",
+			},
+			ehevent.MetaSystemUser(t0)),
+		amdomain.NewMonitorCreated(
+			"m2",
+			true,
+			amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttp,
+				Target: "https://example.net/",
+				Find:   "Example",
+			},
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := amstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtxWithSnapshots(
+			ehreader.TenantId("42"),
+			eventLog,
+			ehreader.NewInMemSnapshotStore()),
+		nil)
+	assert.Ok(t, err)
+
+	setFind := func(find string) func(*amdomain.MonitorConfig) {
+		return func(config *amdomain.MonitorConfig) {
+			config.Find = find
+		}
+	}
+
+	assert.Ok(t, monitorUpdate(ctx, app, "m1", setFind("Example Domain")))
+
+	// our state is stale at this point, so this also tests retry after reload
+	err = monitorUpdate(ctx, app, "m1", setFind("Example Domain"))
+	assert.Assert(t, errors.Is(err, errMonitorUnchanged))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.EqualString(t, amstate.FindMonitorWithId("m1", app.State.Monitors()).Find, "Example Domain")
+
+	err = monitorUpdate(ctx, app, "m1", func(config *amdomain.MonitorConfig) {
+		config.Target = "https://example.net/"
+	})
+	assert.EqualString(t, err.Error(), "conflict: monitor m2 already monitors https://example.net/")
+
+	err = monitorUpdate(ctx, app, "m1", setFind(""))
+	assert.EqualString(t, err.Error(), "http monitor needs string to find")
+
+	err = monitorUpdate(ctx, app, "m3", setFind("Example"))
+	assert.Assert(t, errors.Is(err, errMonitorNotFound))
+}
+
+func TestMonitorTest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+
+		w.Header().Set("X-Powered-By", "coffee")
+		fmt.Fprintln(w, "Welcome to the new page")
+	}))
+	defer server.Close()
+
+	config, _ := defaultMonitorConfig()
+	config.Target = server.URL + "/new"
+	config.Find = "Welcome"
+
+	report := &bytes.Buffer{}
+	assert.Ok(t, monitorTest(context.Background(), config, newHttpScanner(defaultScannerOptions()), report))
+
+	assert.Assert(t, strings.Contains(report.String(), "Status:    200\n"))
+	assert.Assert(t, strings.Contains(report.String(), "  X-Powered-By: coffee\n"))
+	assert.Assert(t, strings.Contains(report.String(), "  Server processing "))
+	assert.Assert(t, strings.HasSuffix(report.String(), "Assertion: ✔️ Welcome\n"))
+
+	config.Target = server.URL + "/old"
+
+	report.Reset()
+	assert.EqualString(t, monitorTest(context.Background(), config, newHttpScanner(defaultScannerOptions()), report).Error(), "check failed")
+
+	assert.Assert(t, strings.Contains(report.String(), "Status:    301\n"))
+	assert.Assert(t, strings.Contains(report.String(), "Redirect:  /new (not followed)\n"))
+	assert.Assert(t, strings.Contains(report.String(), "Assertion: ❌ Welcome\n  string-to-find `Welcome` NOT in body: "))
+}
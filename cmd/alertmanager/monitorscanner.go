@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/logex"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+type monitorFailure struct {
+	err      error
+	monitor  amstate.Monitor
+	snapshot *responseSnapshot
+}
+
+func monitorScanAndAlertFailures(
+	ctx context.Context,
+	monitors []amstate.Monitor,
+	app *amstate.App,
+	startOfScan time.Time,
+) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+
+	opts, err := getScannerOptions()
+	if err != nil {
+		return err
+	}
+
+	failures, results := scanMonitors(
+		ctx,
+		monitors,
+		newRetryScanner(newScanner(opts)),
+		opts,
+		logex.Prefix("scanner", app.Logger))
+
+	return recordResultsAndAlertFailures(ctx, failures, results, "", app, startOfScan)
+}
+
+// for results of checks run by us and by agents ("" = us)
+func recordResultsAndAlertFailures(
+	ctx context.Context,
+	failures []monitorFailure,
+	results []amdomain.MonitorCheckResult,
+	agent string,
+	app *amstate.App,
+	startOfScan time.Time,
+) error {
+	location := agent
+	if location == "" {
+		location = scannerLocation()
+	}
+
+	withLocation := []amdomain.MonitorCheckResult{}
+	for _, result := range results {
+		result.Location = location
+		withLocation = append(withLocation, result)
+	}
+
+	// record results (for history) and check times (so scheduler knows when each monitor
+	// is due again)
+	if err := app.Reader.TransactWrite(ctx, func() error {
+		events := []ehevent.Event{amdomain.NewMonitorsChecked(
+			withLocation,
+			agent,
+			ehevent.MetaSystemUser(startOfScan))}
+
+		// agent is reporting again
+		if agent != "" {
+			if alert := amstate.FindAlertWithSubject((amstate.Agent{Name: agent}).Subject(), app.State.ActiveAlerts()); alert != nil {
+				events = append(events, amdomain.NewAlertAcknowledged(
+					alert.Id,
+					ehevent.MetaSystemUser(startOfScan)))
+			}
+		}
+
+		return app.AppendAfter(ctx, app.State.Version(), events...)
+	}); err != nil {
+		return err
+	}
+
+	// so we see consecutive failure/success counts that include this run
+	if err := app.Reader.LoadUntilRealtime(ctx); err != nil {
+		return err
+	}
+
+	alerts, recovered := alertsAndRecoveries(
+		failures,
+		app.State.Monitors(),
+		app.State.ActiveAlerts(),
+		startOfScan)
+
+	if len(recovered) > 0 {
+		if err := app.Reader.TransactWrite(ctx, func() error {
+			acks := []ehevent.Event{}
+			for _, alert := range recovered {
+				// might've been acked by someone else while we were scanning
+				if !amstate.HasAlertWithId(alert.Id, app.State.ActiveAlerts()) {
+					continue
+				}
+
+				acks = append(acks, amdomain.NewAlertAcknowledged(
+					alert.Id,
+					ehevent.MetaSystemUser(startOfScan)))
+			}
+
+			if len(acks) == 0 {
+				return nil
+			}
+
+			return app.AppendAfter(ctx, app.State.Version(), acks...)
+		}); err != nil {
+			return err
+		}
+	}
+
+	snapshotStore, err := blobStoreFromEnv()
+	if err != nil {
+		return err
+	}
+
+	alerts = storeSnapshotsAndLinkFromAlerts(
+		ctx,
+		alerts,
+		failures,
+		app.State.ActiveAlerts(),
+		snapshotStore,
+		logex.Prefix("snapshots", app.Logger))
+
+	// ok with len(alerts) == 0
+	return ingestAlerts(ctx, alerts, app)
+}
+
+// converts monitor failures into alerts once a monitor has failed enough consecutive runs,
+// and returns active alerts of monitors that have succeeded enough consecutive runs to be
+// considered recovered. monitors need to have counts that include the current run.
+func alertsAndRecoveries(
+	failures []monitorFailure,
+	monitors []amstate.Monitor,
+	activeAlerts []amstate.Alert,
+	now time.Time,
+) ([]amstate.Alert, []amstate.Alert) {
+	alerts := []amstate.Alert{}
+	for _, failure := range failures {
+		monitor := amstate.FindMonitorWithId(failure.monitor.Id, monitors)
+		if monitor == nil { // deleted while scanning
+			continue
+		}
+
+		// quorum and alert-after can't be combined (validation guarantees alertAfter=1), since
+		// consecutive counts mix results from all locations
+		alertAfter := monitor.GetAlertAfterFailures()
+		if monitor.ConsecutiveFailures < alertAfter {
+			continue
+		}
+
+		details := failure.err.Error()
+		if alertAfter > 1 {
+			details = fmt.Sprintf("Failed %d consecutive checks. Latest error: %s", monitor.ConsecutiveFailures, details)
+		}
+
+		if quorum := monitor.GetQuorum(); quorum > 1 {
+			if !monitor.QuorumFailing(now) {
+				continue
+			}
+
+			details = describeFailingLocations(*monitor, now)
+		}
+
+		alerts = append(alerts, amstate.Alert{
+			Id:        amstate.NewAlertId(),
+			Subject:   monitor.Subject(),
+			Details:   details,
+			Timestamp: now,
+		})
+	}
+
+	recovered := []amstate.Alert{}
+	for _, monitor := range monitors {
+		if monitor.RecoverAfterSuccesses == 0 || monitor.ConsecutiveSuccesses < monitor.RecoverAfterSuccesses {
+			continue
+		}
+
+		if alert := amstate.FindAlertWithSubject(monitor.Subject(), activeAlerts); alert != nil {
+			recovered = append(recovered, *alert)
+		}
+	}
+
+	return alerts, recovered
+}
+
+// "Failing in 2 of 3 locations (quorum 2):" followed by each failing location's error
+func describeFailingLocations(monitor amstate.Monitor, now time.Time) string {
+	failing := monitor.FailingLocations(now)
+
+	lines := []string{fmt.Sprintf(
+		"Failing in %d of %d locations (quorum %d):",
+		len(failing),
+		len(monitor.LatestByLocation),
+		monitor.GetQuorum())}
+
+	for _, result := range failing {
+		lines = append(lines, fmt.Sprintf("- %s: %s", result.Location, result.Error))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// identifies where our checks are run from (agents are identified by their name).
+// $SCANNER_LOCATION, or AWS region if we're in AWS
+func scannerLocation() string {
+	if location := os.Getenv("SCANNER_LOCATION"); location != "" {
+		return location
+	}
+
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	return "local"
+}
+
+type scannerOptions struct {
+	workers      int // how many monitors are checked concurrently
+	perHostLimit int // .. of which at most this many against a single host
+}
+
+func defaultScannerOptions() scannerOptions {
+	return scannerOptions{
+		workers:      32,
+		perHostLimit: 4,
+	}
+}
+
+func getScannerOptions() (scannerOptions, error) {
+	opts := defaultScannerOptions()
+
+	for envName, dest := range map[string]*int{
+		"SCANNER_WORKERS":        &opts.workers,
+		"SCANNER_PER_HOST_LIMIT": &opts.perHostLimit,
+	} {
+		fromEnvStr := os.Getenv(envName)
+		if fromEnvStr == "" {
+			continue // default
+		}
+
+		value, err := strconv.Atoi(fromEnvStr)
+		if err != nil || value < 1 {
+			return opts, fmt.Errorf("%s: expecting positive integer; got %s", envName, fromEnvStr)
+		}
+
+		*dest = value
+	}
+
+	return opts, nil
+}
+
+// scans monitors and returns the ones that failed, along with results of all checks
+func scanMonitors(
+	ctx context.Context,
+	monitors []amstate.Monitor,
+	scanner MonitorScanner,
+	opts scannerOptions,
+	logger *log.Logger,
+) ([]monitorFailure, []amdomain.MonitorCheckResult) {
+	logl := logex.Levels(logger)
+
+	failed := []monitorFailure{}
+	results := []amdomain.MonitorCheckResult{}
+	resultsMu := sync.Mutex{} // covers both
+
+	// semaphores. created up-front so workers only read the map
+	hostSlots := map[string]chan struct{}{}
+	for _, monitor := range monitors {
+		if host := monitorHost(monitor); host != "" && hostSlots[host] == nil {
+			hostSlots[host] = make(chan struct{}, opts.perHostLimit)
+		}
+	}
+
+	checkOne := func(monitor amstate.Monitor) {
+		if slots := hostSlots[monitorHost(monitor)]; slots != nil {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+		}
+
+		timings := &checkTimings{}
+
+		// timeout starts only after we got the slot
+		ctx, cancel := context.WithTimeout(httptrace.WithClientTrace(ctx, timings.trace()), monitor.GetTimeout())
+		defer cancel()
+
+		started := time.Now()
+
+		result, err := scanner.Scan(ctx, monitor)
+
+		durationMs := time.Since(started).Milliseconds()
+
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+
+		checkResult := amdomain.MonitorCheckResult{
+			Id:            monitor.Id,
+			Ok:            err == nil,
+			LatencyMs:     int(durationMs),
+			StatusCode:    result.statusCode,
+			DomainExpires: result.domainExpires,
+		}
+		if err != nil {
+			checkResult.Error = err.Error()
+		}
+
+		results = append(results, checkResult)
+
+		if err != nil {
+			snapshot := newResponseSnapshot(monitor, result, err, timings, started)
+
+			failed = append(failed, monitorFailure{
+				err,
+				monitor,
+				&snapshot,
+			})
+
+			logl.Error.Printf("❌ %s @ %d ms => %v", monitor.Subject(), durationMs, err.Error())
+		} else {
+			logl.Debug.Printf("✔️ %s @ %d ms", monitor.Subject(), durationMs)
+		}
+	}
+
+	work := make(chan amstate.Monitor)
+
+	concurrently(opts.workers, func() {
+		for monitor := range work {
+			checkOne(monitor)
+		}
+	}, func() {
+		for _, monitor := range interleaveByHost(monitors) {
+			work <- monitor
+		}
+
+		close(work)
+	})
+
+	return failed, results
+}
+
+// host (with port, if specified) that the check connects to. "" if check doesn't connect to
+// the target (DNS checks talk to our resolver)
+func monitorHost(monitor amstate.Monitor) string {
+	switch monitor.Kind {
+	case amdomain.MonitorKindHttp, amdomain.MonitorKindHttpsAudit:
+		parsed, err := url.Parse(monitor.Target)
+		if err != nil {
+			return ""
+		}
+
+		return parsed.Host
+	case amdomain.MonitorKindTcp, amdomain.MonitorKindGrpc:
+		return monitor.Target
+	case amdomain.MonitorKindHttpTransaction:
+		if len(monitor.Steps) == 0 {
+			return ""
+		}
+
+		parsed, err := url.Parse(monitor.Steps[0].Url)
+		if err != nil {
+			return ""
+		}
+
+		return parsed.Host
+	default:
+		return ""
+	}
+}
+
+// orders monitors so that each host's monitors are spread evenly, so that workers don't all
+// end up waiting for the same host's slots while other hosts' work queues up behind them
+func interleaveByHost(monitors []amstate.Monitor) []amstate.Monitor {
+	hosts := []string{} // in order of first appearance, to keep this deterministic
+	byHost := map[string][]amstate.Monitor{}
+
+	for _, monitor := range monitors {
+		host := monitorHost(monitor)
+		if _, seen := byHost[host]; !seen {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], monitor)
+	}
+
+	interleaved := []amstate.Monitor{}
+	for len(interleaved) < len(monitors) {
+		for _, host := range hosts {
+			if queue := byHost[host]; len(queue) > 0 {
+				interleaved = append(interleaved, queue[0])
+				byHost[host] = queue[1:]
+			}
+		}
+	}
+
+	return interleaved
+}
+
+type scanResult struct {
+	statusCode    int         // 0 if we didn't get a response
+	headers       http.Header // only for HTTP checks that got a response
+	body          []byte      // beginning of body (HTTP)
+	bodyTruncated bool
+	certExpiry    time.Time  // earliest expiry in server's certificate chain. zero if not TLS
+	domainExpires *time.Time // domain_expiry, if it was looked up (vs. cached). zero if lookup failed
+}
+
+type MonitorScanner interface {
+	Scan(context.Context, amstate.Monitor) (scanResult, error)
+}
+
+type retryScanner struct {
+	actualScanner MonitorScanner
+}
+
+// retries once. first try gets half of the monitor's timeout, retry gets what's left.
+func newRetryScanner(actual MonitorScanner) MonitorScanner {
+	return &retryScanner{actual}
+}
+
+func (r *retryScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	firstTryCtx, cancel := context.WithTimeout(ctx, monitor.GetTimeout()/2)
+	defer cancel()
+
+	result, err := r.actualScanner.Scan(firstTryCtx, monitor)
+	if err == nil {
+		return result, nil
+	}
+
+	// domain_expiry lookup was recorded (even a failed one backs off by itself). retrying
+	// would only double the queries to the registries
+	if result.domainExpires != nil {
+		return result, err
+	}
+
+	time.Sleep(2 * time.Second)
+
+	// it'd be hard to detect if we shouldn't retry this at all, since timeouts,
+	// HTTP gateway errors, internal server errors etc. all can be transient
+
+	// now use the longer context
+	result2, err2 := r.actualScanner.Scan(ctx, monitor)
+	if err2 != nil {
+		return result2, fmt.Errorf("first error: %v; retry error: %v", err, err2)
+	}
+
+	return result2, nil
+}
+
+// dispatches to scanner of monitor's kind
+type kindScanner struct {
+	scanners map[amdomain.MonitorKind]MonitorScanner
+}
+
+func newScanner(opts scannerOptions) MonitorScanner {
+	return &kindScanner{map[amdomain.MonitorKind]MonitorScanner{
+		amdomain.MonitorKindHttp: newHttpScanner(opts),
+		amdomain.MonitorKindTcp:  newTcpScanner(),
+		amdomain.MonitorKindDns:  newDnsScanner(),
+		amdomain.MonitorKindGrpc: newGrpcScanner(),
+
+		amdomain.MonitorKindHttpTransaction: newHttpTransactionScanner(opts),
+		amdomain.MonitorKindHttpsAudit:      newHttpsAuditScanner(opts),
+		amdomain.MonitorKindDomainExpiry:    newDomainExpiryScanner(opts),
+	}}
+}
+
+func (k *kindScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	scanner, found := k.scanners[monitor.Kind]
+	if !found {
+		return scanResult{}, fmt.Errorf("no scanner for monitor kind: %s", monitor.Kind)
+	}
+
+	result, err := scanner.Scan(ctx, monitor)
+
+	if monitor.ExpectUnreachable {
+		if err == nil {
+			return result, unexpectedlyReachableError(result)
+		}
+
+		return result, nil // the failure was expected
+	}
+
+	return result, err
+}
+
+func unexpectedlyReachableError(result scanResult) error {
+	if result.statusCode != 0 {
+		return fmt.Errorf("unexpectedly reachable (status %d)", result.statusCode)
+	}
+
+	return errors.New("unexpectedly reachable")
+}
+
+func concurrently(numWorkers int, worker func(), produceWork func()) {
+	workersDone := sync.WaitGroup{}
+
+	for i := 0; i < numWorkers; i++ {
+		workersDone.Add(1)
+		go func() {
+			defer workersDone.Done()
+
+			worker()
+		}()
+	}
+
+	produceWork()
+
+	workersDone.Wait()
+}
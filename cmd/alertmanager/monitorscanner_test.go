@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestOneFails(t *testing.T) {
+	failures, _ := scanMonitors(context.Background(), []amstate.Monitor{
+		httpMonitor("http://example.com/frontpage", "Welcome to"),
+		httpMonitor("http://example.com/contacts", "bar@exmaple.com"),
+	}, &testScanner{}, defaultScannerOptions(), nil)
+
+	assert.Assert(t, len(failures) == 1)
+	assert.EqualString(
+		t,
+		failures[0].err.Error(),
+		"string-to-find `bar@exmaple.com` NOT in body: Contact us by email at foo@example.com")
+}
+
+func TestAllSucceed(t *testing.T) {
+	failures, results := scanMonitors(context.Background(), []amstate.Monitor{
+		httpMonitor("http://example.com/frontpage", "Welcome to"),
+		httpMonitor("http://example.com/contacts", "foo@example.com"),
+	}, &testScanner{}, defaultScannerOptions(), nil)
+
+	assert.Assert(t, len(failures) == 0)
+	assert.Assert(t, len(results) == 2)
+	assert.Assert(t, results[0].Ok && results[0].StatusCode == 200)
+}
+
+func Test404(t *testing.T) {
+	failures, _ := scanMonitors(context.Background(), []amstate.Monitor{
+		httpMonitor("http://notfound.net/", "doesntmatter"),
+	}, &testScanner{}, defaultScannerOptions(), nil)
+
+	assert.Assert(t, len(failures) == 1)
+	assert.EqualString(t, failures[0].err.Error(), "404: http://notfound.net/")
+	assert.EqualJson(t, failures[0].monitor, `{
+  "id": "",
+  "created": "0001-01-01T00:00:00Z",
+  "enabled": false,
+  "kind": "http",
+  "target": "http://notfound.net/",
+  "find": "doesntmatter",
+  "last_checked": "0001-01-01T00:00:00Z"
+}`)
+}
+
+func TestAlertsAndRecoveries(t *testing.T) {
+	monitor := func(id string, alertAfter int, recoverAfter int, failures int, successes int) amstate.Monitor {
+		return amstate.Monitor{
+			Id: id,
+			MonitorConfig: amdomain.MonitorConfig{
+				Kind:                  amdomain.MonitorKindHttp,
+				Target:                "http://" + id + ".net/",
+				AlertAfterFailures:    alertAfter,
+				RecoverAfterSuccesses: recoverAfter,
+			},
+			ConsecutiveFailures:  failures,
+			ConsecutiveSuccesses: successes,
+		}
+	}
+
+	monitors := []amstate.Monitor{
+		monitor("flaky", 3, 0, 2, 0),
+		monitor("down", 3, 0, 3, 0),
+		monitor("legacy", 0, 0, 1, 0),
+		monitor("recovering", 0, 2, 0, 1),
+		monitor("recovered", 0, 2, 0, 2),
+		monitor("manualack", 0, 0, 0, 10),
+	}
+
+	failure := func(id string) monitorFailure {
+		return monitorFailure{
+			err:     fmt.Errorf("500: %s", id),
+			monitor: *amstate.FindMonitorWithId(id, monitors),
+		}
+	}
+
+	activeAlerts := []amstate.Alert{
+		{Id: "a1", Subject: "http://recovering.net/"},
+		{Id: "a2", Subject: "http://recovered.net/"},
+		{Id: "a3", Subject: "http://manualack.net/"},
+	}
+
+	alerts, recovered := alertsAndRecoveries(
+		[]monitorFailure{failure("flaky"), failure("down"), failure("legacy")},
+		monitors,
+		activeAlerts,
+		t0)
+
+	assert.Assert(t, len(alerts) == 2)
+	assert.EqualString(t, alerts[0].Subject, "http://down.net/")
+	assert.EqualString(t, alerts[0].Details, "Failed 3 consecutive checks. Latest error: 500: down")
+	assert.EqualString(t, alerts[1].Subject, "http://legacy.net/")
+	assert.EqualString(t, alerts[1].Details, "500: legacy")
+
+	assert.Assert(t, len(recovered) == 1)
+	assert.EqualString(t, recovered[0].Id, "a2")
+}
+
+func TestQuorumAlerting(t *testing.T) {
+	monitor := amstate.Monitor{
+		Id: "m1",
+		MonitorConfig: amdomain.MonitorConfig{
+			Kind:   amdomain.MonitorKindHttp,
+			Target: "https://example.com/",
+			Quorum: 2,
+		},
+		ConsecutiveFailures: 1,
+	}
+
+	alertsWhenLatestAre := func(results ...amstate.LocationResult) []amstate.Alert {
+		monitor.LatestByLocation = map[string]amstate.LocationResult{}
+		for _, result := range results {
+			monitor.LatestByLocation[result.Location] = result
+		}
+
+		alerts, _ := alertsAndRecoveries(
+			[]monitorFailure{{err: errors.New("timeout"), monitor: monitor}},
+			[]amstate.Monitor{monitor},
+			nil,
+			t0)
+		return alerts
+	}
+
+	assert.Assert(t, len(alertsWhenLatestAre(
+		amstate.LocationResult{Location: "us-east-1", Ok: false, Error: "timeout", Checked: t0},
+		amstate.LocationResult{Location: "eu-west-1", Ok: true, Checked: t0},
+		amstate.LocationResult{Location: "office", Ok: true, Checked: t0},
+	)) == 0)
+
+	alerts := alertsWhenLatestAre(
+		amstate.LocationResult{Location: "us-east-1", Ok: false, Error: "timeout", Checked: t0},
+		amstate.LocationResult{Location: "eu-west-1", Ok: true, Checked: t0},
+		amstate.LocationResult{Location: "office", Ok: false, Error: "502 Bad Gateway", Checked: t0.Add(-1 * time.Minute)},
+	)
+	assert.Assert(t, len(alerts) == 1)
+	assert.EqualString(t, alerts[0].Details, `Failing in 2 of 3 locations (quorum 2):
+- office: 502 Bad Gateway
+- us-east-1: timeout`)
+}
+
+type testScanner struct{}
+
+func (a *testScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	pages := map[string]string{
+		"http://example.com/frontpage": "Welcome to frontpage",
+		"http://example.com/contacts":  "Contact us by email at foo@example.com",
+	}
+
+	page, found := pages[monitor.Target]
+	if !found {
+		return scanResult{statusCode: 404}, fmt.Errorf("404: %s", monitor.Target)
+	}
+
+	return scanResult{statusCode: 200}, mustFindStringInBody(strings.NewReader(page), monitor.Find)
+}
+
+func httpMonitor(url string, find string) amstate.Monitor {
+	return amstate.Monitor{
+		MonitorConfig: amdomain.MonitorConfig{
+			Kind:   amdomain.MonitorKindHttp,
+			Target: url,
+			Find:   find,
+		},
+	}
+}
+
+func TestTcpScanner(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Ok(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	tcpMonitor := func(target string) amstate.Monitor {
+		return amstate.Monitor{MonitorConfig: amdomain.MonitorConfig{
+			Kind:   amdomain.MonitorKindTcp,
+			Target: target,
+		}}
+	}
+
+	_, err = newScanner(defaultScannerOptions()).Scan(context.Background(), tcpMonitor(listener.Addr().String()))
+	assert.Ok(t, err)
+
+	// grab a port that nobody listens on
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Ok(t, err)
+	closedAddr := closedListener.Addr().String()
+	closedListener.Close()
+
+	_, err = newScanner(defaultScannerOptions()).Scan(context.Background(), tcpMonitor(closedAddr))
+	assert.Assert(t, err != nil)
+}
+
+func TestMustHaveExpectedDnsValues(t *testing.T) {
+	assert.Ok(t, mustHaveExpectedDnsValues([]string{"192.0.2.1"}, nil))
+	assert.Ok(t, mustHaveExpectedDnsValues([]string{"Mail.Example.com."}, []string{"mail.example.com"}))
+
+	assert.EqualString(
+		t,
+		mustHaveExpectedDnsValues([]string{"192.0.2.1", "192.0.2.2"}, []string{"192.0.2.2", "192.0.2.3"}).Error(),
+		"expected value(s) 192.0.2.3 NOT in DNS answer: 192.0.2.1, 192.0.2.2")
+	assert.EqualString(
+		t,
+		mustHaveExpectedDnsValues([]string{}, nil).Error(),
+		"no records in DNS answer")
+}
+
+func TestGrpcScanner(t *testing.T) {
+	statuses := map[string]uint64{
+		"":            grpcServing,
+		"healthy.Svc": grpcServing,
+		"sick.Svc":    2,
+	}
+
+	// minimal grpc.health.v1 server
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grpc.health.v1.Health/Check" || r.Header.Get("Content-Type") != "application/grpc" {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		msg, _ := grpcUnframe(body)
+
+		service := ""
+		if len(msg) > 2 { // skip field tag and length (short names only)
+			service = string(msg[2:])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		status, found := statuses[service]
+		if !found {
+			w.Header().Set("Grpc-Status", "5") // NOT_FOUND
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+
+		_, _ = w.Write(grpcFrame([]byte{0x08, byte(status)}))
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer server.Close()
+
+	scan := func(service string) string {
+		_, err := newScanner(defaultScannerOptions()).Scan(context.Background(), amstate.Monitor{MonitorConfig: amdomain.MonitorConfig{
+			Kind:        amdomain.MonitorKindGrpc,
+			Target:      strings.TrimPrefix(server.URL, "http://"),
+			GrpcService: service,
+		}})
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+
+	assert.EqualString(t, scan(""), "ok")
+	assert.EqualString(t, scan("healthy.Svc"), "ok")
+	assert.EqualString(t, scan("sick.Svc"), "health status NOT_SERVING")
+	assert.EqualString(t, scan("nonexistent.Svc"), "grpc-status 5: unknown service")
+}
+
+func TestMustFindStringInBody(t *testing.T) {
+	// needle straddles reads
+	assert.Ok(t, mustFindStringInBody(iotest.OneByteReader(strings.NewReader("Hello world")), "lo wo"))
+
+	longBody := "Lorem\n  ipsum " + strings.Repeat("a", 300)
+
+	assert.EqualString(
+		t,
+		mustFindStringInBody(strings.NewReader(longBody), "dolor").Error(),
+		"string-to-find `dolor` NOT in body: Lorem ipsum "+strings.Repeat("a", 186)+" [...]")
+
+	// doesn't read beyond limit even if the string would come later
+	assert.EqualString(
+		t,
+		mustFindStringInBody(strings.NewReader(strings.Repeat("a", maxBodyBytesToSearch)+"dolor"), "dolor").Error(),
+		"string-to-find `dolor` NOT in first 2097152 bytes of body: "+strings.Repeat("a", 200)+" [...]")
+}
+
+func TestInterleaveByHost(t *testing.T) {
+	subjects := []string{}
+	for _, monitor := range interleaveByHost([]amstate.Monitor{
+		httpMonitor("https://a.com/1", "x"),
+		httpMonitor("https://a.com/2", "x"),
+		httpMonitor("https://a.com/3", "x"),
+		httpMonitor("https://b.com/1", "x"),
+		httpMonitor("https://c.com/1", "x"),
+		httpMonitor("https://c.com/2", "x"),
+	}) {
+		subjects = append(subjects, monitor.Subject())
+	}
+
+	assert.EqualString(t, strings.Join(subjects, " "), "https://a.com/1 https://b.com/1 https://c.com/1 https://a.com/2 https://c.com/2 https://a.com/3")
+}
+
+// 300 monitors against 10 slow hosts with big pages, comparing old fixed pool of 3 workers
+// to the default options
+func BenchmarkScanMonitors(b *testing.B) {
+	page := "<h1>Welcome</h1>" + strings.Repeat("<p>Lorem ipsum dolor sit amet</p>\n", 30000) // ~1 MB
+
+	monitors := []amstate.Monitor{}
+
+	for i := 0; i < 10; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+
+			fmt.Fprint(w, page)
+		}))
+		defer server.Close()
+
+		for j := 0; j < 30; j++ {
+			monitors = append(monitors, httpMonitor(fmt.Sprintf("%s/page/%d", server.URL, j), "Welcome"))
+		}
+	}
+
+	for _, opts := range []scannerOptions{
+		{workers: 3, perHostLimit: 3},
+		defaultScannerOptions(),
+	} {
+		opts := opts
+
+		b.Run(fmt.Sprintf("workers=%d perHost=%d", opts.workers, opts.perHostLimit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				failures, _ := scanMonitors(context.Background(), monitors, newHttpScanner(opts), opts, nil)
+				if len(failures) > 0 {
+					b.Fatal(failures[0].err)
+				}
+			}
+		})
+	}
+}
+
+func TestHttpScannerIdleConnsFollowPerHostLimit(t *testing.T) {
+	opts := defaultScannerOptions()
+	opts.perHostLimit = 7 // as if from SCANNER_PER_HOST_LIMIT
+
+	transport := newHttpScanner(opts).noRedirects.Transport.(*http.Transport)
+	assert.Assert(t, transport.MaxIdleConnsPerHost == 7)
+}
+
+func TestHttpTransactionScanner(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("password") != "hunter2" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t"})
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"user": {"csrf_tokens": ["abc123"]}}`)
+	})
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "s3cr3t" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
+		fmt.Fprintf(w, "<h1>Welcome back</h1> csrf=%s", r.Header.Get("X-Csrf"))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	monitor := func(password string) amstate.Monitor {
+		return amstate.Monitor{
+			MonitorConfig: amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttpTransaction,
+				Target: "login flow",
+				Steps: []amdomain.HttpStep{
+					{
+						Name:    "login",
+						Method:  "post",
+						Url:     server.URL + "/login",
+						Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+						Body:    "user=admin&password=" + password,
+						Captures: []amdomain.HttpCapture{
+							{Name: "csrf", JsonPath: "user.csrf_tokens.0"},
+							{Name: "contentType", Header: "Content-Type"},
+						},
+					},
+					{
+						Url:          server.URL + "/dashboard",
+						Headers:      map[string]string{"X-Csrf": "{{csrf}}"},
+						ExpectStatus: http.StatusOK,
+						Find:         "Welcome back",
+						Captures: []amdomain.HttpCapture{
+							{Name: "echoedCsrf", Regex: `csrf=([a-z0-9]+)`},
+						},
+					},
+					{
+						Url:           server.URL + "/dashboard?csrf={{echoedCsrf}}",
+						ExpectHeaders: map[string]string{"Content-Type": "text/html"},
+					},
+				},
+			},
+		}
+	}
+
+	assert.Ok(t, validateMonitorConfig(monitorConfigWithDefaults(monitor("hunter2").MonitorConfig)))
+
+	result, err := newHttpTransactionScanner(defaultScannerOptions()).Scan(context.Background(), monitor("hunter2"))
+	assert.Ok(t, err)
+	assert.Assert(t, result.statusCode == http.StatusOK)
+
+	result, err = newHttpTransactionScanner(defaultScannerOptions()).Scan(context.Background(), monitor("wrong"))
+	assert.EqualString(t, err.Error(), "step 1 (login): got status 401")
+	assert.Assert(t, result.statusCode == http.StatusUnauthorized)
+
+	// without the cookie from login we get redirected back to login
+	withoutLogin := monitor("hunter2")
+	withoutLogin.Steps = withoutLogin.Steps[1:]
+	withoutLogin.Steps[0].Headers = nil
+
+	_, err = newHttpTransactionScanner(defaultScannerOptions()).Scan(context.Background(), withoutLogin)
+	assert.EqualString(t, err.Error(), fmt.Sprintf("step 1 (GET %s/dashboard): expected status 200; got 302", server.URL))
+
+	withoutLogin.Steps[0].Headers = map[string]string{"X-Csrf": "{{csrf}}"}
+	assert.EqualString(
+		t,
+		validateMonitorConfig(monitorConfigWithDefaults(withoutLogin.MonitorConfig)).Error(),
+		fmt.Sprintf("step 1 (GET %s/dashboard): variable csrf not captured by previous steps", server.URL))
+}
+
+func TestNegativeMonitors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin":
+			fmt.Fprintln(w, "Admin login")
+		case "/":
+			fmt.Fprintln(w, "<div class='banner'>Maintenance in progress</div> Welcome")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	scan := func(config amdomain.MonitorConfig) error {
+		assert.Ok(t, validateMonitorConfig(monitorConfigWithDefaults(config)))
+
+		_, err := newScanner(defaultScannerOptions()).Scan(context.Background(), amstate.Monitor{MonitorConfig: config})
+		return err
+	}
+
+	assert.Ok(t, scan(amdomain.MonitorConfig{
+		Kind:         amdomain.MonitorKindHttp,
+		Target:       server.URL + "/.git/config",
+		ExpectStatus: http.StatusNotFound,
+	}))
+
+	assert.EqualString(t, scan(amdomain.MonitorConfig{
+		Kind:         amdomain.MonitorKindHttp,
+		Target:       server.URL + "/admin",
+		ExpectStatus: http.StatusNotFound,
+	}).Error(), "expected status 404; got 200")
+
+	assert.EqualString(t, scan(amdomain.MonitorConfig{
+		Kind:              amdomain.MonitorKindHttp,
+		Target:            server.URL + "/admin",
+		Find:              "Admin login",
+		ExpectUnreachable: true,
+	}).Error(), "unexpectedly reachable (status 200)")
+
+	// 404 is not reachable when we don't look for content
+	assert.Ok(t, scan(amdomain.MonitorConfig{
+		Kind:              amdomain.MonitorKindHttp,
+		Target:            server.URL + "/.git/config",
+		ExpectUnreachable: true,
+	}))
+
+	assert.EqualString(t, scan(amdomain.MonitorConfig{
+		Kind:    amdomain.MonitorKindHttp,
+		Target:  server.URL + "/",
+		Find:    "Welcome",
+		NotFind: "Maintenance",
+	}).Error(), "must-not-contain string `Maintenance` found in body: <div class='banner'>Maintenance in progress</div> Welcome")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Ok(t, err)
+	closedPort := listener.Addr().String()
+	assert.Ok(t, listener.Close())
+
+	assert.Ok(t, scan(amdomain.MonitorConfig{
+		Kind:              amdomain.MonitorKindTcp,
+		Target:            closedPort,
+		ExpectUnreachable: true,
+	}))
+}
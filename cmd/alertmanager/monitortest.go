@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/spf13/cobra"
+)
+
+func monitorTestEntry() *cobra.Command {
+	config, kind := defaultMonitorConfig()
+
+	cmd := &cobra.Command{
+		Use:   "test [target] [find]",
+		Short: "Run a check once (without creating a monitor) and print a detailed report",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := getScannerOptions()
+			exitIfError(err)
+
+			exitIfError(monitorTest(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				monitorConfigFromArgs(config, kind, args),
+				newRetryScanner(newScanner(opts)),
+				os.Stdout))
+		},
+	}
+
+	monitorConfigFlags(cmd, &config, &kind)
+
+	return cmd
+}
+
+// runs the check like the scheduler would, but doesn't write any events
+func monitorTest(
+	ctx context.Context,
+	config amdomain.MonitorConfig,
+	scanner MonitorScanner,
+	out io.Writer,
+) error {
+	if err := validateMonitorConfig(config); err != nil {
+		return err
+	}
+
+	monitor := amstate.Monitor{Enabled: true, MonitorConfig: config}
+
+	timings := &checkTimings{}
+
+	ctx, cancel := context.WithTimeout(httptrace.WithClientTrace(ctx, timings.trace()), monitor.GetTimeout())
+	defer cancel()
+
+	started := time.Now()
+
+	result, err := scanner.Scan(ctx, monitor)
+
+	printResponseSnapshot(out, newResponseSnapshot(monitor, result, err, timings, started))
+
+	if err != nil {
+		fmt.Fprintf(out, "Assertion: ❌ %s\n  %v\n", describeExpectation(config), err)
+
+		return errors.New("check failed")
+	}
+
+	fmt.Fprintf(out, "Assertion: ✔️ %s\n", describeExpectation(config))
+
+	return nil
+}
+
+// records timestamps of HTTP request phases. if the check was retried, timestamps are of the
+// last try.
+type checkTimings struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+type checkPhase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (c *checkTimings) trace() *httptrace.ClientTrace {
+	record := func(dest *time.Time) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		*dest = time.Now()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart:             func(_ httptrace.DNSStartInfo) { record(&c.dnsStart) },
+		DNSDone:              func(_ httptrace.DNSDoneInfo) { record(&c.dnsDone) },
+		ConnectStart:         func(_, _ string) { record(&c.connectStart) },
+		ConnectDone:          func(_, _ string, _ error) { record(&c.connectDone) },
+		TLSHandshakeStart:    func() { record(&c.tlsStart) },
+		TLSHandshakeDone:     func(_ tls.ConnectionState, _ error) { record(&c.tlsDone) },
+		WroteRequest:         func(_ httptrace.WroteRequestInfo) { record(&c.wroteRequest) },
+		GotFirstResponseByte: func() { record(&c.firstByte) },
+	}
+}
+
+// phases that happened (e.g. there's no DNS lookup for IP addresses)
+func (c *checkTimings) phases() []checkPhase {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	phases := []checkPhase{}
+
+	for _, phase := range []struct {
+		name  string
+		start time.Time
+		end   time.Time
+	}{
+		{"DNS lookup", c.dnsStart, c.dnsDone},
+		{"TCP connect", c.connectStart, c.connectDone},
+		{"TLS handshake", c.tlsStart, c.tlsDone},
+		{"Server processing", c.wroteRequest, c.firstByte},
+	} {
+		if phase.start.IsZero() || phase.end.Before(phase.start) {
+			continue
+		}
+
+		phases = append(phases, checkPhase{phase.name, phase.end.Sub(phase.start)})
+	}
+
+	return phases
+}
@@ -0,0 +1,174 @@
+package main
+
+// Prometheus blackbox exporter -compatible probe endpoint, so Prometheus can scrape our scanner:
+//
+//     GET /probe?target=https://example.com/&module=http_2xx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+const defaultProbeModule = "http_2xx"
+
+// modules have same settings as monitors declared for "$ mon apply" (except target, which comes
+// from the probe request)
+type probeModulesFile struct {
+	Modules map[string]declaredMonitor `json:"modules" yaml:"modules"`
+}
+
+// built-in modules named like in blackbox exporter
+func builtinProbeModules() map[string]declaredMonitor {
+	return map[string]declaredMonitor{
+		"http_2xx":    {Kind: string(amdomain.MonitorKindHttp)},
+		"tcp_connect": {Kind: string(amdomain.MonitorKindTcp)},
+	}
+}
+
+// built-ins, plus ones from PROBE_MODULES file (which can override built-ins)
+func probeModules() (map[string]declaredMonitor, error) {
+	modules := builtinProbeModules()
+
+	path := os.Getenv("PROBE_MODULES")
+	if path == "" {
+		return modules, nil
+	}
+
+	fromFile := probeModulesFile{}
+	if err := readConfigFile(path, &fromFile); err != nil {
+		return nil, err
+	}
+
+	for name, module := range fromFile.Modules {
+		modules[name] = module
+	}
+
+	return modules, nil
+}
+
+type probeResult struct {
+	success    bool
+	duration   time.Duration
+	statusCode int       // 0 if not HTTP
+	certExpiry time.Time // zero if not TLS
+}
+
+func probe(
+	ctx context.Context,
+	module declaredMonitor,
+	target string,
+	scrapeTimeout time.Duration,
+	scanner MonitorScanner,
+) (probeResult, error) {
+	module.Target = target
+
+	// not validated like monitors are, since e.g. HTTP probes without find-string are fine
+	// (status is then checked)
+	config, err := module.toMonitorConfigWithoutValidation()
+	if err != nil {
+		return probeResult{}, err
+	}
+
+	monitor := amstate.Monitor{MonitorConfig: config}
+
+	timeout := monitor.GetTimeout()
+	if scrapeTimeout != 0 && scrapeTimeout < timeout {
+		timeout = scrapeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+
+	result, err := scanner.Scan(ctx, monitor)
+
+	return probeResult{
+		success:    err == nil,
+		duration:   time.Since(started),
+		statusCode: result.statusCode,
+		certExpiry: result.certExpiry,
+	}, nil
+}
+
+// failed probe is not an error (for Prometheus), but a bad probe request is
+func handleProbe(w http.ResponseWriter, r *http.Request, scanner MonitorScanner) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	moduleName := r.URL.Query().Get("module")
+	if moduleName == "" {
+		moduleName = defaultProbeModule
+	}
+
+	modules, err := probeModules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	module, found := modules[moduleName]
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown module: %s", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	result, err := probe(r.Context(), module, target, scrapeTimeoutFromRequest(r), scanner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	noCacheHeaders(w)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeProbeMetrics(w, result)
+}
+
+// Prometheus tells how long it waits for us. leave some margin for responding.
+func scrapeTimeoutFromRequest(r *http.Request) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 1*time.Second {
+		timeout -= 500 * time.Millisecond
+	}
+
+	return timeout
+}
+
+func writeProbeMetrics(w io.Writer, result probeResult) {
+	gauge := func(name string, help string, value string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, value)
+	}
+
+	gauge("probe_success", "Displays whether or not the probe was a success", boolToMetric(result.success))
+	gauge("probe_duration_seconds", "Returns how long the probe took to complete in seconds", strconv.FormatFloat(result.duration.Seconds(), 'f', -1, 64))
+	gauge("probe_http_status_code", "Response HTTP status code", strconv.Itoa(result.statusCode))
+
+	if !result.certExpiry.IsZero() {
+		gauge("probe_ssl_earliest_cert_expiry", "Returns earliest SSL cert expiry in unixtime", strconv.FormatInt(result.certExpiry.Unix(), 10))
+	}
+}
+
+func boolToMetric(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, "Welcome to our site")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// trusts the test server's certificate
+	scanner := newHttpScanner(defaultScannerOptions())
+	scanner.noRedirects.Transport = server.Client().Transport
+
+	tempDir, err := ioutil.TempDir("", "probe_test")
+	assert.Ok(t, err)
+	defer os.RemoveAll(tempDir)
+
+	modulesPath := filepath.Join(tempDir, "modules.yaml")
+	assert.Ok(t, ioutil.WriteFile(modulesPath, []byte(`modules:
+  welcome:
+    find: Welcome to
+  gone:
+    expect_status: 404
+`), 0600))
+
+	os.Setenv("PROBE_MODULES", modulesPath)
+	defer os.Unsetenv("PROBE_MODULES")
+
+	probeRequest := func(path string, module string) *httptest.ResponseRecorder {
+		query := url.Values{"target": {server.URL + path}}
+		if module != "" {
+			query.Set("module", module)
+		}
+
+		resp := httptest.NewRecorder()
+		handleProbe(resp, httptest.NewRequest(http.MethodGet, "/probe?"+query.Encode(), nil), scanner)
+		return resp
+	}
+
+	metrics := func(resp *httptest.ResponseRecorder) string {
+		lines := []string{}
+		for _, line := range strings.Split(resp.Body.String(), "\n") {
+			// duration varies
+			if strings.HasPrefix(line, "probe_") && !strings.HasPrefix(line, "probe_duration_seconds") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	certExpiry := server.Certificate().NotAfter.Unix()
+
+	resp := probeRequest("/", "")
+	assert.Assert(t, resp.Code == http.StatusOK)
+	assert.EqualString(t, resp.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Assert(t, strings.Contains(resp.Body.String(), "# TYPE probe_success gauge\n"))
+	assert.Assert(t, strings.Contains(resp.Body.String(), "\nprobe_duration_seconds "))
+	assert.EqualString(t, metrics(resp), fmt.Sprintf(`probe_success 1
+probe_http_status_code 200
+probe_ssl_earliest_cert_expiry %d`, certExpiry))
+
+	// default module doesn't accept error pages
+	assert.EqualString(t, metrics(probeRequest("/notfound", "http_2xx")), fmt.Sprintf(`probe_success 0
+probe_http_status_code 404
+probe_ssl_earliest_cert_expiry %d`, certExpiry))
+
+	assert.EqualString(t, metrics(probeRequest("/", "welcome")), fmt.Sprintf(`probe_success 1
+probe_http_status_code 200
+probe_ssl_earliest_cert_expiry %d`, certExpiry))
+
+	assert.EqualString(t, metrics(probeRequest("/", "gone")), fmt.Sprintf(`probe_success 0
+probe_http_status_code 200
+probe_ssl_earliest_cert_expiry %d`, certExpiry))
+
+	assert.EqualString(t, metrics(probeRequest("/notfound", "gone")), fmt.Sprintf(`probe_success 1
+probe_http_status_code 404
+probe_ssl_earliest_cert_expiry %d`, certExpiry))
+
+	// unreachable
+	notListening := httptest.NewServer(http.NotFoundHandler())
+	notListening.Close()
+
+	resp = httptest.NewRecorder()
+	handleProbe(resp, httptest.NewRequest(http.MethodGet, "/probe?target="+url.QueryEscape(notListening.URL), nil), scanner)
+	assert.EqualString(t, metrics(resp), `probe_success 0
+probe_http_status_code 0`)
+
+	assert.Assert(t, probeRequest("/", "nonexistent").Code == http.StatusBadRequest)
+
+	resp = httptest.NewRecorder()
+	handleProbe(resp, httptest.NewRequest(http.MethodGet, "/probe", nil), scanner)
+	assert.Assert(t, resp.Code == http.StatusBadRequest)
+}
+
+func TestScrapeTimeoutFromRequest(t *testing.T) {
+	timeoutFromHeader := func(header string) string {
+		req := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if header != "" {
+			req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", header)
+		}
+		return scrapeTimeoutFromRequest(req).String()
+	}
+
+	assert.EqualString(t, timeoutFromHeader(""), "0s")
+	assert.EqualString(t, timeoutFromHeader("garbage"), "0s")
+	assert.EqualString(t, timeoutFromHeader("10"), "9.5s")
+	assert.EqualString(t, timeoutFromHeader("0.5"), "500ms")
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/function61/gokit/httputils"
@@ -15,6 +17,7 @@ import (
 	"github.com/function61/gokit/ossignal"
 	"github.com/function61/gokit/taskrunner"
 	"github.com/function61/lambda-alertmanager/pkg/alertmanagertypes"
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
 	"github.com/function61/lambda-alertmanager/pkg/amstate"
 	"github.com/spf13/cobra"
 )
@@ -69,22 +72,47 @@ func newRestApi(ctx context.Context) http.Handler {
 		fmt.Fprintf(w, "Ack ok for %s", id)
 	})
 
+	mux.GET.HandleFunc("/alerts/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		store, err := blobStoreFromEnv()
+		if err != nil || store == nil {
+			http.Error(w, "snapshot storage not configured", http.StatusInternalServerError)
+			return
+		}
+
+		snapshot, err := loadSnapshot(r.Context(), r.URL.Query().Get("id"), store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		handleJsonOutput(w, snapshot)
+	})
+
 	mux.GET.HandleFunc("/deadmansswitches", func(w http.ResponseWriter, r *http.Request) {
 		noCacheHeaders(w)
 
 		handleJsonOutput(w, app.State.DeadMansSwitches())
 	})
 
-	// /deadmansswitch/checkin?subject=ubackup_done&ttl=24h30m
+	// /deadmansswitch/checkin?subject=ubackup_done&ttl=24h30m[&message=...&field=size_gb=42][&status=fail]
 	mux.GET.HandleFunc("/deadmansswitch/checkin", func(w http.ResponseWriter, r *http.Request) {
 		// same semantic hack here as acknowledge endpoint
 
 		noCacheHeaders(w)
 
+		fields, err := parseCheckinFields(r.URL.Query()["field"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// handles validation
 		handleDeadMansSwitchCheckin(w, r, alertmanagertypes.DeadMansSwitchCheckinRequest{
 			Subject: r.URL.Query().Get("subject"),
 			TTL:     r.URL.Query().Get("ttl"),
+			Message: r.URL.Query().Get("message"),
+			Fields:  fields,
+			Status:  r.URL.Query().Get("status"),
 		}, app)
 	})
 
@@ -99,6 +127,198 @@ func newRestApi(ctx context.Context) http.Handler {
 		handleDeadMansSwitchCheckin(w, r, checkin, app)
 	})
 
+	mux.POST.HandleFunc("/deadmansswitch/create", func(w http.ResponseWriter, r *http.Request) {
+		create := alertmanagertypes.DeadMansSwitchCreateRequest{}
+		if err := jsonfile.Unmarshal(r.Body, &create, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+
+		firstDeadline, err := parseTtlSpec(create.FirstDeadline, now)
+		if err != nil {
+			http.Error(w, "first_deadline: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		metadata := deadMansSwitchMetadata{
+			Description: create.Description,
+			Owner:       create.Owner,
+			Runbook:     create.Runbook,
+		}
+
+		if err := validateDeadMansSwitchCreate(create.Subject, firstDeadline, metadata, now); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := deadmansswitchCreate(r.Context(), create.Subject, firstDeadline, metadata, app, now); err != nil {
+			if err == errDeadMansSwitchExists {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, "Switch created")
+	})
+
+	mux.POST.HandleFunc("/deadmansswitch/start", func(w http.ResponseWriter, r *http.Request) {
+		start := alertmanagertypes.DeadMansSwitchStartRequest{}
+		if err := jsonfile.Unmarshal(r.Body, &start, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if start.Subject == "" {
+			http.Error(w, "subject empty", http.StatusBadRequest)
+			return
+		}
+
+		maxRuntime, err := time.ParseDuration(start.MaxRuntime)
+		if err != nil {
+			http.Error(w, "max_runtime: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := deadmansswitchStart(r.Context(), start.Subject, maxRuntime, app, time.Now()); err != nil {
+			if err == errMaxRuntimeNotPositive {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		fmt.Fprintln(w, "Start noted")
+	})
+
+	// /monitors/{id}/stats
+	monitorsGet := func(w http.ResponseWriter, r *http.Request) {
+		noCacheHeaders(w)
+
+		id, action := monitorIdAndActionFromPath(r.URL.Path)
+		if action != "stats" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if amstate.FindMonitorWithId(id, app.State.Monitors()) == nil {
+			http.Error(w, "monitor not found", http.StatusNotFound)
+			return
+		}
+
+		handleJsonOutput(w, monitorStats(app.State.MonitorHistory(id), time.Now()))
+	}
+
+	// /monitors/{id} with full monitor config as body
+	monitorsPut := func(w http.ResponseWriter, r *http.Request) {
+		id, action := monitorIdAndActionFromPath(r.URL.Path)
+		if id == "" || action != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		config := amdomain.MonitorConfig{}
+		if err := jsonfile.Unmarshal(r.Body, &config, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateMonitorConfig(monitorConfigWithDefaults(config)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := mustHaveAgents(config.Agents, app.State.Agents()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := monitorUpdate(r.Context(), app, id, func(existing *amdomain.MonitorConfig) {
+			*existing = config
+		})
+		switch {
+		case err == nil, errors.Is(err, errMonitorUnchanged): // PUT is idempotent
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, errMonitorNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errMonitorConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+
+	mux.GET.HandleFunc("/monitors/", monitorsGet)
+	mux.PUT.HandleFunc("/monitors/", monitorsPut)
+	// from when we only had HTTP monitors
+	mux.GET.HandleFunc("/httpmonitors/", monitorsGet)
+	mux.PUT.HandleFunc("/httpmonitors/", monitorsPut)
+
+	// for agents. authenticated with agent's token
+	mux.GET.HandleFunc("/agent/monitors", func(w http.ResponseWriter, r *http.Request) {
+		noCacheHeaders(w)
+
+		// state might be from this Lambda instance's cold start, and the agent newer than that
+		if err := app.Reader.LoadUntilRealtime(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		agent := agentFromRequest(r, app.State.Agents())
+		if agent == nil {
+			http.Error(w, "invalid agent token", http.StatusUnauthorized)
+			return
+		}
+
+		handleJsonOutput(w, agentDueMonitors(*agent, app, time.Now()))
+	})
+
+	mux.POST.HandleFunc("/agent/results", func(w http.ResponseWriter, r *http.Request) {
+		// state might be from this Lambda instance's cold start, and the agent newer than that
+		if err := app.Reader.LoadUntilRealtime(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		agent := agentFromRequest(r, app.State.Agents())
+		if agent == nil {
+			http.Error(w, "invalid agent token", http.StatusUnauthorized)
+			return
+		}
+
+		report := agentReport{}
+		if err := jsonfile.Unmarshal(r.Body, &report, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := agentReportResults(r.Context(), *agent, report, app, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// same SCANNER_* settings as the scheduler. misconfiguration only fails probing
+	scannerOpts, scannerOptsErr := getScannerOptions()
+	prober := newScanner(scannerOpts)
+
+	// for Prometheus, like blackbox exporter
+	mux.GET.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		if scannerOptsErr != nil {
+			http.Error(w, scannerOptsErr.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		handleProbe(w, r, prober)
+	})
+
 	mux.POST.HandleFunc("/prometheus-alertmanager/api/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not implemented yet", http.StatusInternalServerError)
 	})
@@ -112,22 +332,45 @@ func handleDeadMansSwitchCheckin(
 	raw alertmanagertypes.DeadMansSwitchCheckinRequest,
 	app *amstate.App,
 ) {
-	if raw.Subject == "" || raw.TTL == "" {
-		http.Error(w, "subject or ttl empty", http.StatusBadRequest)
+	if raw.Subject == "" {
+		http.Error(w, "subject empty", http.StatusBadRequest)
 		return
 	}
 
 	now := time.Now()
 
-	ttl, err := parseTtlSpec(raw.TTL, now)
-	if err != nil {
+	ttl := time.Time{} // switch must have a schedule
+	if raw.TTL != "" {
+		var err error
+		ttl, err = parseTtlSpec(raw.TTL, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := validateCheckinPayload(raw.Message, raw.Fields); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	alertAcked, err := deadmansswitchCheckin(r.Context(), raw.Subject, ttl, app, time.Now())
+	switch raw.Status {
+	case "", alertmanagertypes.CheckinStatusOk:
+	case alertmanagertypes.CheckinStatusFail:
+		handleDeadMansSwitchFailure(w, r, raw, ttl, app)
+		return
+	default:
+		http.Error(w, "unsupported status: "+raw.Status, http.StatusBadRequest)
+		return
+	}
+
+	alertAcked, err := deadmansswitchCheckin(r.Context(), raw.Subject, ttl, raw.Message, raw.Fields, app, time.Now())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, errTtlRequired) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -138,6 +381,49 @@ func handleDeadMansSwitchCheckin(
 	}
 }
 
+func handleDeadMansSwitchFailure(
+	w http.ResponseWriter,
+	r *http.Request,
+	raw alertmanagertypes.DeadMansSwitchCheckinRequest,
+	ttl time.Time,
+	app *amstate.App,
+) {
+	if len(raw.Fields) > 0 {
+		http.Error(w, errFieldsWithFailure.Error(), http.StatusBadRequest)
+		return
+	}
+
+	alertRaised, err := deadmansswitchReportFailure(r.Context(), raw.Subject, ttl, raw.Message, app, time.Now())
+	if err != nil {
+		if errors.Is(err, errTtlRequired) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if alertRaised {
+		fmt.Fprintln(w, "Failure noted; alert raised")
+	} else {
+		fmt.Fprintln(w, "Failure noted; alert already firing (or rate limited)")
+	}
+}
+
+// "/monitors/abc123/stats" => "abc123", "stats"
+func monitorIdAndActionFromPath(path string) (string, string) {
+	// first component is "monitors" or "httpmonitors"
+	parts := strings.Split(strings.Trim(path, "/"), "/")[1:]
+	switch len(parts) {
+	case 0:
+		return "", ""
+	case 1:
+		return parts[0], ""
+	default:
+		return parts[0], strings.Join(parts[1:], "/")
+	}
+}
+
 func handleJsonOutput(w http.ResponseWriter, output interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 
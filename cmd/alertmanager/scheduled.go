@@ -29,7 +29,17 @@ func handleCloudwatchScheduledEvent(ctx context.Context, now time.Time) error {
 		return err
 	}
 
-	if err := httpMonitorScanAndAlertFailures(ctx, app); err != nil {
+	if err := alertForSilentAgents(ctx, app, now); err != nil {
+		return err
+	}
+
+	// agents' monitors are checked by the agents
+	dueMonitors := amstate.DueMonitors(
+		amstate.EnabledMonitors(amstate.MonitorsCheckedBy("", app.State.Monitors())),
+		scannerLocation(),
+		now)
+
+	if err := monitorScanAndAlertFailures(ctx, dueMonitors, app, now); err != nil {
 		return err
 	}
 
@@ -97,34 +107,69 @@ func checkAndAlertForUnnoticedAlerts(
 func alertForExpiredDeadMansSwitches(ctx context.Context, app *amstate.App, now time.Time) error {
 	candidateAlerts := []amstate.Alert{}
 
+	// hung job is more specific than a late check-in, so it takes precedence
+	overdueRun := map[string]bool{}
+
+	for _, dms := range amstate.GetOverdueDeadMansSwitchRuns(app.State.DeadMansSwitches(), now) {
+		candidateAlerts = append(candidateAlerts, overdueRunToAlert(dms, now))
+		overdueRun[dms.Subject] = true
+	}
+
 	for _, dms := range amstate.GetExpiredDeadMansSwitches(app.State.DeadMansSwitches(), now) {
-		candidateAlerts = append(candidateAlerts, deadMansSwitchToAlert(dms, now))
+		if !overdueRun[dms.Subject] {
+			candidateAlerts = append(candidateAlerts, deadMansSwitchToAlert(dms, now))
+		}
 	}
 
 	// ok with len(alerts) == 0
 	return ingestAlerts(ctx, candidateAlerts, app)
 }
 
-var plusDayAtStaticTimeRe = regexp.MustCompile(`^\+([0-9]+)d@([0-9]{2}):([0-9]{2})$`)
+// agents' monitors aren't being checked while an agent is silent, so that's worth an alert
+// in itself. the alert is acked when the agent reports again.
+func alertForSilentAgents(ctx context.Context, app *amstate.App, now time.Time) error {
+	candidateAlerts := []amstate.Alert{}
 
-func parseTtlSpec(spec string, now time.Time) (time.Time, error) {
-	// +1d@12:00
-	if match := plusDayAtStaticTimeRe.FindStringSubmatch(spec); match != nil {
-		// below errors should never trigger because regexp guarantees they're in good format
-		day, err := strconv.Atoi(match[1])
-		if err != nil {
-			return time.Time{}, fmt.Errorf("bad day component: %v", err)
-		}
-		hour, err := strconv.Atoi(match[2])
-		if err != nil {
-			return time.Time{}, fmt.Errorf("bad hour component: %v", err)
-		}
-		minute, err := strconv.Atoi(match[3])
-		if err != nil {
-			return time.Time{}, fmt.Errorf("bad minute component: %v", err)
-		}
+	for _, agent := range amstate.GetSilentAgents(app.State.Agents(), now) {
+		candidateAlerts = append(candidateAlerts, amstate.Alert{
+			Id:      amstate.NewAlertId(),
+			Subject: agent.Subject(),
+			Details: fmt.Sprintf(
+				"Agent has not reported for %s (last seen %s). Its %d monitor(s) are not being checked.",
+				now.Sub(agent.LastSeen()).Round(time.Minute),
+				agent.LastSeen().Format(time.RFC3339),
+				len(amstate.MonitorsCheckedBy(agent.Name, app.State.Monitors()))),
+			Timestamp: now,
+		})
+	}
 
-		return time.Date(now.Year(), now.Month(), now.Day()+day, hour, minute, 0, 0, time.UTC), nil
+	// ok with len(alerts) == 0
+	return ingestAlerts(ctx, candidateAlerts, app)
+}
+
+// "+1d@12:00", "+2bd@09:00 Europe/Helsinki" (business days), "mon@09:00" or "friday@17:00 Europe/Helsinki"
+var calendarTtlRe = regexp.MustCompile(`^(?:\+([0-9]+)(d|bd)|([a-zA-Z]+))@([0-9]{2}):([0-9]{2})(?: ([^ ]+))?$`)
+
+var ttlWeekdays = map[string]time.Weekday{}
+
+func init() {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		ttlWeekdays[name] = day
+		ttlWeekdays[name[0:3]] = day
+	}
+}
+
+// TTL spec is one of:
+// - "+24h": duration from now
+// - "+1d@12:00": time of day N calendar days from now
+// - "+1bd@12:00": time of day N business days (Mon-Fri) from now
+// - "mon@09:00": time of day on next such weekday (never today, so a weekly job can use it)
+// - "2019-09-10T01:13:00Z": absolute time
+// calendar forms are in UTC unless followed by an IANA time zone ("+1d@12:00 Europe/Helsinki").
+func parseTtlSpec(spec string, now time.Time) (time.Time, error) {
+	if match := calendarTtlRe.FindStringSubmatch(spec); match != nil {
+		return parseCalendarTtlSpec(match, now)
 	} else if strings.HasPrefix(spec, "+") { // +24h
 		duration, err := time.ParseDuration(spec[1:])
 		if err != nil {
@@ -141,11 +186,135 @@ func parseTtlSpec(spec string, now time.Time) (time.Time, error) {
 	}
 }
 
+func parseCalendarTtlSpec(match []string, now time.Time) (time.Time, error) {
+	// below Atoi() errors should never trigger because regexp guarantees they're in good format
+	hour, err := strconv.Atoi(match[4])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad hour component: %v", err)
+	}
+	minute, err := strconv.Atoi(match[5])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad minute component: %v", err)
+	}
+	if hour > 23 || minute > 59 {
+		return time.Time{}, fmt.Errorf("time of day out of range: %s:%s", match[4], match[5])
+	}
+
+	loc := time.UTC
+	if match[6] != "" {
+		loc, err = time.LoadLocation(match[6])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("time zone: %v", err)
+		}
+	}
+
+	// days are counted in the target time zone's calendar, so DST changes don't shift the time of day
+	today := now.In(loc)
+
+	days := 0
+	switch {
+	case match[3] != "": // weekday
+		weekday, found := ttlWeekdays[strings.ToLower(match[3])]
+		if !found {
+			return time.Time{}, fmt.Errorf("not a weekday: %s", match[3])
+		}
+
+		days = (int(weekday)-int(today.Weekday())+6)%7 + 1
+	case match[2] == "bd":
+		businessDays, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad day component: %v", err)
+		}
+
+		days = businessDaysAhead(today.Weekday(), businessDays)
+	default:
+		days, err = strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad day component: %v", err)
+		}
+	}
+
+	return time.Date(today.Year(), today.Month(), today.Day()+days, hour, minute, 0, 0, loc).UTC(), nil
+}
+
+// how many calendar days until we've passed given amount of business days (Mon-Fri)
+func businessDaysAhead(from time.Weekday, businessDays int) int {
+	days := 0
+	for weekday := from; businessDays > 0; {
+		days++
+		weekday = (weekday + 1) % 7
+
+		if weekday != time.Saturday && weekday != time.Sunday {
+			businessDays--
+		}
+	}
+
+	return days
+}
+
 func deadMansSwitchToAlert(dms amstate.DeadMansSwitch, now time.Time) amstate.Alert {
+	return deadMansSwitchAlert(dms, fmt.Sprintf("Check-in late by %s (%s)", now.Sub(dms.Ttl), dms.Ttl.Format(time.RFC3339Nano)), now)
+}
+
+// same subject as the late check-in alert, so a check-in acks either
+func overdueRunToAlert(dms amstate.DeadMansSwitch, now time.Time) amstate.Alert {
+	return deadMansSwitchAlert(dms, fmt.Sprintf(
+		"Job %s started at %s but did not finish within %s",
+		dms.Subject,
+		dms.Run.Started.Format(time.RFC3339),
+		dms.Run.MaxRuntime), now)
+}
+
+// dms should already have the failure in its history (see WithFailureReported)
+func failureReportToAlert(dms amstate.DeadMansSwitch, message string, now time.Time) amstate.Alert {
+	details := "Job reported failure"
+	if message != "" {
+		details += ": " + message
+	}
+
+	return deadMansSwitchAlert(dms, details, now)
+}
+
+// details are followed by switch's metadata and recent check-ins (with run durations), for context
+func deadMansSwitchAlert(dms amstate.DeadMansSwitch, details string, now time.Time) amstate.Alert {
+	metadata := []string{}
+	for _, field := range []struct {
+		label string
+		value string
+	}{
+		{"Description", dms.Description},
+		{"Owner", dms.Owner},
+		{"Runbook", dms.Runbook},
+	} {
+		if field.value != "" {
+			metadata = append(metadata, field.label+": "+field.value)
+		}
+	}
+
+	if len(metadata) > 0 {
+		details += "\n\n" + strings.Join(metadata, "\n")
+	}
+
+	if len(dms.History) > 0 {
+		lines := []string{}
+		for i := len(dms.History) - 1; i >= 0; i-- { // most recent first
+			checkin := dms.History[i]
+
+			line := "- " + checkin.Time.Format(time.RFC3339)
+			if description := describeCheckin(checkin); description != "" {
+				line += " " + description
+			}
+
+			lines = append(lines, line)
+		}
+
+		details += "\n\nRecent check-ins:\n" + strings.Join(lines, "\n")
+	}
+
 	return amstate.Alert{
 		Id:        amstate.NewAlertId(),
 		Subject:   dms.Subject,
-		Details:   fmt.Sprintf("Check-in late by %s (%s)", now.Sub(dms.Ttl), dms.Ttl.Format(time.RFC3339Nano)),
+		Details:   details,
 		Timestamp: now,
 	}
 }
@@ -119,10 +119,50 @@ func TestParseTtlSpec(t *testing.T) {
 			"+14d@10:00",
 			"2019-09-21T10:00:00Z",
 		},
+		{
+			"+1d@12:00 Europe/Helsinki",
+			"2019-09-08T09:00:00Z",
+		},
+		{
+			"+0d@18:00 Europe/Helsinki",
+			"2019-09-07T15:00:00Z",
+		},
+		{
+			"mon@09:00", // t0 is a Saturday
+			"2019-09-09T09:00:00Z",
+		},
+		{
+			"sat@09:00", // never today
+			"2019-09-14T09:00:00Z",
+		},
+		{
+			"Friday@17:00 Europe/Helsinki",
+			"2019-09-13T14:00:00Z",
+		},
+		{
+			"+1bd@09:00",
+			"2019-09-09T09:00:00Z",
+		},
+		{
+			"+6bd@09:00",
+			"2019-09-16T09:00:00Z",
+		},
 		{
 			"2019-09-10T01:13:00Z",
 			"2019-09-10T01:13:00Z",
 		},
+		{
+			"+1d@12:00 Mars/Olympus_Mons",
+			"error: time zone: unknown time zone Mars/Olympus_Mons",
+		},
+		{
+			"+1d@24:00",
+			"error: time of day out of range: 24:00",
+		},
+		{
+			"someday@09:00",
+			"error: not a weekday: someday",
+		},
 		{
 			"foobar",
 			"error: not in RFC3339: foobar",
@@ -152,3 +192,22 @@ func TestParseTtlSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTtlSpecAcrossDstChange(t *testing.T) {
+	ttl := func(spec string, now string) string {
+		nowTs, err := time.Parse(time.RFC3339, now)
+		assert.Ok(t, err)
+
+		ttl, err := parseTtlSpec(spec, nowTs)
+		assert.Ok(t, err)
+		return ttl.Format(time.RFC3339)
+	}
+
+	// Helsinki moves from UTC+2 to UTC+3 on 2020-03-29 at 03:00
+	assert.EqualString(t, ttl("+1d@12:00 Europe/Helsinki", "2020-03-27T08:00:00Z"), "2020-03-28T10:00:00Z")
+	assert.EqualString(t, ttl("+1d@12:00 Europe/Helsinki", "2020-03-28T08:00:00Z"), "2020-03-29T09:00:00Z")
+	// ... and back on 2020-10-25 at 04:00
+	assert.EqualString(t, ttl("+1bd@12:00 Europe/Helsinki", "2020-10-23T08:00:00Z"), "2020-10-26T10:00:00Z")
+	// late evening in UTC is already the next day in Helsinki
+	assert.EqualString(t, ttl("+1d@12:00 Europe/Helsinki", "2020-03-27T22:30:00Z"), "2020-03-29T09:00:00Z")
+}
@@ -0,0 +1,252 @@
+package main
+
+// Snapshots of failed checks' responses, so one can see what the site was responding with
+// even if it has recovered by the time one looks at the alert.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/function61/gokit/logex"
+	"github.com/function61/gokit/ossignal"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+	"github.com/spf13/cobra"
+)
+
+var snapshotAlertIdRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// anyone with a snapshot link can read the snapshot, so these must not end up in it
+var sensitiveHeaders = []string{
+	"Set-Cookie",
+	"Cookie",
+	"Authorization",
+	"Proxy-Authorization",
+}
+
+type responseSnapshot struct {
+	MonitorId     string        `json:"monitor_id"`
+	Subject       string        `json:"subject"`
+	Timestamp     time.Time     `json:"timestamp"`
+	Error         string        `json:"error,omitempty"`
+	StatusCode    int           `json:"status_code,omitempty"`
+	Headers       http.Header   `json:"headers,omitempty"`
+	Body          string        `json:"body,omitempty"`
+	BodyTruncated bool          `json:"body_truncated,omitempty"`
+	Timings       []checkPhase  `json:"timings"`
+	Duration      time.Duration `json:"duration"`
+}
+
+func newResponseSnapshot(
+	monitor amstate.Monitor,
+	result scanResult,
+	err error,
+	timings *checkTimings,
+	started time.Time,
+) responseSnapshot {
+	snapshot := responseSnapshot{
+		MonitorId:     monitor.Id,
+		Subject:       monitor.Subject(),
+		Timestamp:     started,
+		StatusCode:    result.statusCode,
+		Headers:       redactSensitiveHeaders(result.headers),
+		Body:          string(result.body),
+		BodyTruncated: result.bodyTruncated,
+		Timings:       timings.phases(),
+		Duration:      time.Since(started),
+	}
+
+	if err != nil {
+		snapshot.Error = err.Error()
+	}
+
+	return snapshot
+}
+
+// header is kept (with redacted value) so one can still see it was sent
+func redactSensitiveHeaders(headers http.Header) http.Header {
+	if headers == nil {
+		return nil
+	}
+
+	redacted := headers.Clone()
+	for _, name := range sensitiveHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "[redacted]")
+		}
+	}
+
+	return redacted
+}
+
+func monitorSnapshotEntry() *cobra.Command {
+	return &cobra.Command{
+		Use:   "snapshot [alertId]",
+		Short: "Show response snapshot of the failed check that raised an alert",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exitIfError(snapshotPrint(
+				ossignal.InterruptOrTerminateBackgroundCtx(nil),
+				args[0],
+				os.Stdout))
+		},
+	}
+}
+
+func snapshotPrint(ctx context.Context, alertId string, out io.Writer) error {
+	store, err := blobStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return errors.New("snapshot storage not configured")
+	}
+
+	snapshot, err := loadSnapshot(ctx, alertId, store)
+	if err != nil {
+		return err
+	}
+
+	printResponseSnapshot(out, *snapshot)
+
+	fmt.Fprintf(out, "Error:     %s\n", snapshot.Error)
+
+	truncatedMaybe := ""
+	if snapshot.BodyTruncated {
+		truncatedMaybe = " (truncated)"
+	}
+
+	fmt.Fprintf(out, "Body%s:\n%s\n", truncatedMaybe, snapshot.Body)
+
+	return nil
+}
+
+// parts that are common for snapshot view and "$ mon test"
+func printResponseSnapshot(out io.Writer, snapshot responseSnapshot) {
+	fmt.Fprintf(out, "Monitor:   %s\n", snapshot.Subject)
+
+	if snapshot.StatusCode != 0 {
+		fmt.Fprintf(out, "Status:    %d\n", snapshot.StatusCode)
+	}
+
+	if location := snapshot.Headers.Get("Location"); location != "" && snapshot.StatusCode/100 == 3 {
+		fmt.Fprintf(out, "Redirect:  %s (not followed)\n", location)
+	}
+
+	if len(snapshot.Headers) > 0 {
+		fmt.Fprintln(out, "Headers:")
+
+		keys := []string{}
+		for key := range snapshot.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			for _, value := range snapshot.Headers[key] {
+				fmt.Fprintf(out, "  %s: %s\n", key, value)
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "Timings:")
+	for _, phase := range snapshot.Timings {
+		fmt.Fprintf(out, "  %-20s %s\n", phase.Name, phase.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(out, "  %-20s %s\n", "Total", snapshot.Duration.Round(time.Millisecond))
+}
+
+// stores snapshots of the failures that caused the alerts and links them from alert details.
+// alerts that'd get deduplicated (there's already an active alert) get no snapshot.
+// failing to store a snapshot is not worth failing alerting for.
+func storeSnapshotsAndLinkFromAlerts(
+	ctx context.Context,
+	alerts []amstate.Alert,
+	failures []monitorFailure,
+	activeAlerts []amstate.Alert,
+	store blobStore,
+	logger *log.Logger,
+) []amstate.Alert {
+	if store == nil {
+		return alerts
+	}
+
+	withLinks := []amstate.Alert{}
+
+	for _, alert := range alerts {
+		for _, failure := range failures {
+			if failure.snapshot == nil || failure.monitor.Subject() != alert.Subject {
+				continue
+			}
+
+			if amstate.FindAlertWithSubject(alert.Subject, activeAlerts) != nil {
+				continue
+			}
+
+			if err := storeSnapshot(ctx, alert.Id, *failure.snapshot, store); err != nil {
+				logex.Levels(logger).Error.Printf("storing snapshot for %s: %v", alert.Subject, err)
+				continue
+			}
+
+			alert.Details += "\n\nResponse snapshot: " + snapshotLink(alert.Id)
+		}
+
+		withLinks = append(withLinks, alert)
+	}
+
+	return withLinks
+}
+
+func storeSnapshot(ctx context.Context, alertId string, snapshot responseSnapshot, store blobStore) error {
+	key, err := snapshotKey(alertId)
+	if err != nil {
+		return err
+	}
+
+	asJson, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return store.Put(ctx, key, asJson)
+}
+
+func loadSnapshot(ctx context.Context, alertId string, store blobStore) (*responseSnapshot, error) {
+	key, err := snapshotKey(alertId)
+	if err != nil {
+		return nil, err
+	}
+
+	asJson, err := store.Get(ctx, key)
+	if err != nil {
+		if err == errBlobNotFound {
+			return nil, fmt.Errorf("no snapshot for alert %s", alertId)
+		}
+
+		return nil, err
+	}
+
+	snapshot := &responseSnapshot{}
+	return snapshot, json.Unmarshal(asJson, snapshot)
+}
+
+func snapshotKey(alertId string) (string, error) {
+	// alert IDs come from user input in some places, and keys can be file paths
+	if !snapshotAlertIdRe.MatchString(alertId) {
+		return "", fmt.Errorf("invalid alert id: %s", alertId)
+	}
+
+	return "snapshots/" + alertId + ".json", nil
+}
+
+func snapshotLink(alertId string) string {
+	return os.Getenv("API_ENDPOINT") + "/alerts/snapshot?id=" + alertId
+}
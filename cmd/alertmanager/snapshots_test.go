@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+func TestNewResponseSnapshotRedactsSensitiveHeaders(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Server", "nginx")
+	headers.Add("Set-Cookie", "session=hunter2")
+	headers.Add("Set-Cookie", "csrf=s3cret")
+	headers.Set("Authorization", "Bearer hunter2")
+
+	snapshot := newResponseSnapshot(
+		httpMonitor("https://example.com/", "Welcome"),
+		scanResult{statusCode: 500, headers: headers},
+		errors.New("Internal Server Error"),
+		&checkTimings{},
+		t0)
+
+	assert.EqualJson(t, snapshot.Headers, `{
+  "Authorization": [
+    "[redacted]"
+  ],
+  "Server": [
+    "nginx"
+  ],
+  "Set-Cookie": [
+    "[redacted]"
+  ]
+}`)
+
+	// scan result is not mutated
+	assert.EqualString(t, headers.Get("Set-Cookie"), "session=hunter2")
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "snapshots")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newLocalDirBlobStore(dir)
+
+	down := httpMonitor("https://down.net/", "Welcome")
+	flapping := httpMonitor("https://flapping.net/", "Welcome")
+
+	failure := func(monitor amstate.Monitor) monitorFailure {
+		return monitorFailure{
+			err:     errors.New("string-to-find `Welcome` NOT in body: Bad Gateway"),
+			monitor: monitor,
+			snapshot: &responseSnapshot{
+				Subject:    monitor.Subject(),
+				Timestamp:  t0,
+				Error:      "string-to-find `Welcome` NOT in body: Bad Gateway",
+				StatusCode: 502,
+				Headers:    http.Header{"Server": {"nginx"}},
+				Body:       "Bad Gateway",
+			},
+		}
+	}
+
+	alerts := storeSnapshotsAndLinkFromAlerts(
+		ctx,
+		[]amstate.Alert{
+			{Id: "a1", Subject: down.Subject(), Details: "down"},
+			{Id: "a2", Subject: flapping.Subject(), Details: "flapping"},
+		},
+		[]monitorFailure{failure(down), failure(flapping)},
+		[]amstate.Alert{{Id: "a0", Subject: flapping.Subject()}}, // already alerting
+		store,
+		nil)
+
+	assert.EqualString(t, alerts[0].Details, "down\n\nResponse snapshot: "+os.Getenv("API_ENDPOINT")+"/alerts/snapshot?id=a1")
+	assert.EqualString(t, alerts[1].Details, "flapping")
+
+	_, err = loadSnapshot(ctx, "a2", store)
+	assert.EqualString(t, err.Error(), "no snapshot for alert a2")
+
+	_, err = loadSnapshot(ctx, "../../etc/passwd", store)
+	assert.EqualString(t, err.Error(), "invalid alert id: ../../etc/passwd")
+
+	os.Setenv("SNAPSHOT_DIR", dir)
+	defer os.Unsetenv("SNAPSHOT_DIR")
+
+	output := &bytes.Buffer{}
+	assert.Ok(t, snapshotPrint(ctx, "a1", output))
+
+	assert.EqualString(t, output.String(), `Monitor:   https://down.net/
+Status:    502
+Headers:
+  Server: nginx
+Timings:
+  Total                0s
+Error:     string-to-find `+"`Welcome`"+` NOT in body: Bad Gateway
+Body:
+Bad Gateway
+`)
+}
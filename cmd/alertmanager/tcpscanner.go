@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/function61/lambda-alertmanager/pkg/amstate"
+)
+
+// checks that a TCP port accepts connections (and optionally completes a TLS handshake),
+// e.g. for SMTP, Postgres or Redis
+type tcpScanner struct {
+	dialer *net.Dialer
+}
+
+func newTcpScanner() *tcpScanner {
+	return &tcpScanner{&net.Dialer{}}
+}
+
+func (t *tcpScanner) Scan(ctx context.Context, monitor amstate.Monitor) (scanResult, error) {
+	conn, err := t.dialer.DialContext(ctx, "tcp", monitor.Target)
+	if err != nil {
+		return scanResult{}, err
+	}
+	defer conn.Close()
+
+	if !monitor.Tls {
+		return scanResult{}, nil
+	}
+
+	host, _, err := net.SplitHostPort(monitor.Target)
+	if err != nil {
+		return scanResult{}, err
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+
+	// handshake doesn't take context
+	if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+		if err := tlsConn.SetDeadline(deadline); err != nil {
+			return scanResult{}, err
+		}
+	}
+
+	return scanResult{}, tlsConn.Handshake()
+}
@@ -10,9 +10,11 @@ require (
 	github.com/function61/eventhorizon v0.2.1-0.20200227140656-f89fe5d462ca
 	github.com/function61/gokit v0.0.0-20200307135016-6dd948616ce0
 	github.com/mattn/go-runewidth v0.0.8 // indirect
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/scylladb/termtables v1.0.0
 	github.com/spf13/cobra v0.0.6
 	github.com/stretchr/testify v1.5.1 // indirect
 	github.com/tj/assert v0.0.0-20190920132354-ee03d75cd160 // indirect
-	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b // indirect
+	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -40,6 +40,22 @@ func (c *Client) DeadMansSwitchCheckin(
 			"+"+ttl.String()))
 }
 
+// check-in with a message and/or numeric fields, which are shown in the alert if the switch
+// later goes late
+func (c *Client) DeadMansSwitchCheckinWithPayload(
+	ctx context.Context,
+	subject string,
+	ttl time.Duration,
+	message string,
+	fields map[string]float64,
+) error {
+	req := alertmanagertypes.NewDeadMansSwitchCheckinRequest(subject, "+"+ttl.String())
+	req.Message = message
+	req.Fields = fields
+
+	return c.DeadMansSwitchCheckinCustom(ctx, req)
+}
+
 func (c *Client) DeadMansSwitchCheckinCustom(
 	ctx context.Context,
 	req alertmanagertypes.DeadMansSwitchCheckinRequest,
@@ -48,6 +64,43 @@ func (c *Client) DeadMansSwitchCheckinCustom(
 	return err
 }
 
+// creates a switch before its first check-in. firstDeadline is in the same format as TTL in check-ins.
+func (c *Client) DeadMansSwitchCreate(
+	ctx context.Context,
+	req alertmanagertypes.DeadMansSwitchCreateRequest,
+) error {
+	_, err := ezhttp.Post(ctx, c.baseUrl+"/deadmansswitch/create", ezhttp.SendJson(&req))
+	return err
+}
+
+// raises an alert right away. the switch's deadline is unchanged, so the next successful
+// check-in acks the alert.
+func (c *Client) DeadMansSwitchReportFailure(
+	ctx context.Context,
+	subject string,
+	message string,
+) error {
+	req := alertmanagertypes.NewDeadMansSwitchCheckinRequest(subject, "")
+	req.Message = message
+	req.Status = alertmanagertypes.CheckinStatusFail
+
+	return c.DeadMansSwitchCheckinCustom(ctx, req)
+}
+
+// marks a run of the job as started. if the job doesn't check in within maxRuntime, we
+// alert that it started but didn't finish.
+func (c *Client) DeadMansSwitchStart(
+	ctx context.Context,
+	subject string,
+	maxRuntime time.Duration,
+) error {
+	_, err := ezhttp.Post(ctx, c.baseUrl+"/deadmansswitch/start", ezhttp.SendJson(&alertmanagertypes.DeadMansSwitchStartRequest{
+		Subject:    subject,
+		MaxRuntime: maxRuntime.String(),
+	}))
+	return err
+}
+
 // if ALERTMANAGER_BASEURL is set, returns client
 func ClientFromEnvOptional() *Client {
 	baseUrl := os.Getenv(baseUrlEnvVarName)
@@ -39,10 +39,18 @@ func (d *DeadMansSwitch) AsAlert(now time.Time) Alert {
 
 // otherwise the same but TTL in un-expanded form
 type DeadMansSwitchCheckinRequest struct {
-	Subject string `json:"subject"`
-	TTL     string `json:"ttl"`
+	Subject string             `json:"subject"`
+	TTL     string             `json:"ttl"`               // "+24h", "+1d@12:00 Europe/Helsinki", "+1bd@09:00", "mon@09:00" or RFC3339. can be empty if switch has a schedule
+	Message string             `json:"message,omitempty"` // optional, e.g. "backed up 42 GB in 17m"
+	Fields  map[string]float64 `json:"fields,omitempty"`  // optional, e.g. {"size_gb": 42}
+	Status  string             `json:"status,omitempty"`  // "" or "ok" = success, "fail" = alert now (Message has the error)
 }
 
+const (
+	CheckinStatusOk   = "ok"
+	CheckinStatusFail = "fail"
+)
+
 func (d *DeadMansSwitchCheckinRequest) AsAlert(details string) Alert {
 	return Alert{
 		Subject:   d.Subject,
@@ -51,6 +59,21 @@ func (d *DeadMansSwitchCheckinRequest) AsAlert(details string) Alert {
 	}
 }
 
+// registers a switch before its first check-in, so a job that never runs is noticed too
+type DeadMansSwitchCreateRequest struct {
+	Subject       string `json:"subject"`
+	FirstDeadline string `json:"first_deadline"`        // same format as check-in's TTL
+	Description   string `json:"description,omitempty"` // optional, shown in alerts
+	Owner         string `json:"owner,omitempty"`       // optional, shown in alerts
+	Runbook       string `json:"runbook,omitempty"`     // optional URL, shown in alerts
+}
+
+// marks a job as running. next check-in completes the run.
+type DeadMansSwitchStartRequest struct {
+	Subject    string `json:"subject"`
+	MaxRuntime string `json:"max_runtime"` // "2h". alert if no check-in within this
+}
+
 func NewDeadMansSwitchCheckinRequest(subject string, ttl string) DeadMansSwitchCheckinRequest {
 	return DeadMansSwitchCheckinRequest{
 		Subject: subject,
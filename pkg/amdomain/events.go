@@ -8,15 +8,29 @@ import (
 )
 
 var Types = ehevent.Allocators{
-	"AlertRaised":               func() ehevent.Event { return &AlertRaised{} },
-	"AlertAcknowledged":         func() ehevent.Event { return &AlertAcknowledged{} },
-	"UnnoticedAlertsNotified":   func() ehevent.Event { return &UnnoticedAlertsNotified{} },
-	"HttpMonitorCreated":        func() ehevent.Event { return &HttpMonitorCreated{} },
-	"HttpMonitorEnabledUpdated": func() ehevent.Event { return &HttpMonitorEnabledUpdated{} },
-	"HttpMonitorDeleted":        func() ehevent.Event { return &HttpMonitorDeleted{} },
-	"DeadMansSwitchCreated":     func() ehevent.Event { return &DeadMansSwitchCreated{} },
-	"DeadMansSwitchCheckin":     func() ehevent.Event { return &DeadMansSwitchCheckin{} },
-	"DeadMansSwitchDeleted":     func() ehevent.Event { return &DeadMansSwitchDeleted{} },
+	"AlertRaised":                   func() ehevent.Event { return &AlertRaised{} },
+	"AlertAcknowledged":             func() ehevent.Event { return &AlertAcknowledged{} },
+	"UnnoticedAlertsNotified":       func() ehevent.Event { return &UnnoticedAlertsNotified{} },
+	"HttpMonitorCreated":            func() ehevent.Event { return &HttpMonitorCreated{} },
+	"HttpMonitorEnabledUpdated":     func() ehevent.Event { return &HttpMonitorEnabledUpdated{} },
+	"HttpMonitorDeleted":            func() ehevent.Event { return &HttpMonitorDeleted{} },
+	"HttpMonitorsChecked":           func() ehevent.Event { return &HttpMonitorsChecked{} },
+	"MonitorCreated":                func() ehevent.Event { return &MonitorCreated{} },
+	"MonitorEnabledUpdated":         func() ehevent.Event { return &MonitorEnabledUpdated{} },
+	"MonitorUpdated":                func() ehevent.Event { return &MonitorUpdated{} },
+	"MonitorDeleted":                func() ehevent.Event { return &MonitorDeleted{} },
+	"MonitorsChecked":               func() ehevent.Event { return &MonitorsChecked{} },
+	"DeadMansSwitchCreated":         func() ehevent.Event { return &DeadMansSwitchCreated{} },
+	"DeadMansSwitchCheckin":         func() ehevent.Event { return &DeadMansSwitchCheckin{} },
+	"DeadMansSwitchDeleted":         func() ehevent.Event { return &DeadMansSwitchDeleted{} },
+	"DeadMansSwitchScheduleUpdated": func() ehevent.Event { return &DeadMansSwitchScheduleUpdated{} },
+	"DeadMansSwitchRunStarted":      func() ehevent.Event { return &DeadMansSwitchRunStarted{} },
+	"DeadMansSwitchFailureReported": func() ehevent.Event { return &DeadMansSwitchFailureReported{} },
+	"DeadMansSwitchPaused":          func() ehevent.Event { return &DeadMansSwitchPaused{} },
+	"DeadMansSwitchResumed":         func() ehevent.Event { return &DeadMansSwitchResumed{} },
+	"DeadMansSwitchMetadataUpdated": func() ehevent.Event { return &DeadMansSwitchMetadataUpdated{} },
+	"AgentCreated":                  func() ehevent.Event { return &AgentCreated{} },
+	"AgentDeleted":                  func() ehevent.Event { return &AgentDeleted{} },
 }
 
 // ------
@@ -87,12 +101,19 @@ func NewUnnoticedAlertsNotified(
 
 // ------
 
+// HTTP monitor events predate monitor kinds. they're still projected (as kind=http monitors),
+// but new events are written as Monitor* events.
+
 type HttpMonitorCreated struct {
-	meta    ehevent.EventMeta
-	Id      string
-	Enabled bool
-	Url     string
-	Find    string
+	meta                  ehevent.EventMeta
+	Id                    string
+	Enabled               bool
+	Url                   string
+	Find                  string
+	Interval              time.Duration // zero = default (events created before intervals were introduced)
+	Timeout               time.Duration // zero = default
+	AlertAfterFailures    int           // zero = default
+	RecoverAfterSuccesses int           // zero = no automatic recovery
 }
 
 func (e *HttpMonitorCreated) MetaType() string         { return "HttpMonitorCreated" }
@@ -103,14 +124,22 @@ func NewHttpMonitorCreated(
 	enabled bool,
 	url string,
 	find string,
+	interval time.Duration,
+	timeout time.Duration,
+	alertAfterFailures int,
+	recoverAfterSuccesses int,
 	meta ehevent.EventMeta,
 ) *HttpMonitorCreated {
 	return &HttpMonitorCreated{
-		meta:    meta,
-		Id:      id,
-		Enabled: enabled,
-		Url:     url,
-		Find:    find,
+		meta:                  meta,
+		Id:                    id,
+		Enabled:               enabled,
+		Url:                   url,
+		Find:                  find,
+		Interval:              interval,
+		Timeout:               timeout,
+		AlertAfterFailures:    alertAfterFailures,
+		RecoverAfterSuccesses: recoverAfterSuccesses,
 	}
 }
 
@@ -159,6 +188,234 @@ func NewHttpMonitorDeleted(
 
 // ------
 
+// scheduler ran checks for these monitors (event timestamp is the time of the run)
+type HttpMonitorsChecked struct {
+	meta    ehevent.EventMeta
+	Ids     []string // only in events written before we started recording results
+	Results []MonitorCheckResult
+}
+
+func (e *HttpMonitorsChecked) MetaType() string         { return "HttpMonitorsChecked" }
+func (e *HttpMonitorsChecked) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewHttpMonitorsChecked(
+	results []MonitorCheckResult,
+	meta ehevent.EventMeta,
+) *HttpMonitorsChecked {
+	return &HttpMonitorsChecked{
+		meta:    meta,
+		Results: results,
+	}
+}
+
+// ------
+
+type MonitorKind string
+
+const (
+	MonitorKindHttp MonitorKind = "http"
+	MonitorKindTcp  MonitorKind = "tcp"
+	MonitorKindDns  MonitorKind = "dns"
+	MonitorKindGrpc MonitorKind = "grpc"
+	// ordered list of HTTP requests sharing a cookie jar (e.g. log in, then load a page)
+	MonitorKindHttpTransaction MonitorKind = "http_transaction"
+	// security headers & HTTPS hygiene of a site (not whether it's up)
+	MonitorKindHttpsAudit MonitorKind = "https_audit"
+	// registration expiry of target's registrable domain (via RDAP or WHOIS)
+	MonitorKindDomainExpiry MonitorKind = "domain_expiry"
+)
+
+// JSON tags because this is embedded in the projected monitor, which is JSON-serialized with
+// snake-cased keys
+type MonitorConfig struct {
+	Kind   MonitorKind `json:"kind"`
+	Target string      `json:"target"` // URL for http & https_audit, host:port for tcp & grpc, hostname for dns, name for http_transaction, URL or hostname for domain_expiry
+	// kind-specific
+	Find            string            `json:"find,omitempty"`              // http
+	NotFind         string            `json:"not_find,omitempty"`          // http (must not be in body)
+	ExpectStatus    int               `json:"expect_status,omitempty"`     // http (zero = any non-error status, or any status if find given)
+	DnsRecordType   string            `json:"dns_record_type,omitempty"`   // dns
+	Expect          []string          `json:"expect,omitempty"`            // dns (all of these must be in the answer)
+	GrpcService     string            `json:"grpc_service,omitempty"`      // grpc ("" = server's overall health)
+	Tls             bool              `json:"tls,omitempty"`               // tcp & grpc
+	Steps           []HttpStep        `json:"steps,omitempty"`             // http_transaction
+	Audit           *HttpsAuditPolicy `json:"audit,omitempty"`             // https_audit (nil = default policy)
+	ExpiryAlertDays int               `json:"expiry_alert_days,omitempty"` // domain_expiry (zero = default)
+	// for things that must not be reachable (e.g. staging admin panel). all kinds.
+	ExpectUnreachable bool `json:"expect_unreachable,omitempty"`
+	// scheduling & alerting
+	Agents                []string      `json:"agents,omitempty"`                  // empty = checked by the scheduler
+	Quorum                int           `json:"quorum,omitempty"`                  // how many locations must see a failure. zero = 1
+	QuorumWindow          time.Duration `json:"quorum_window,omitempty"`           // how recent their failures must be. zero = default
+	Interval              time.Duration `json:"interval,omitempty"`                // zero = default
+	Timeout               time.Duration `json:"timeout,omitempty"`                 // zero = default
+	AlertAfterFailures    int           `json:"alert_after_failures,omitempty"`    // zero = default
+	RecoverAfterSuccesses int           `json:"recover_after_successes,omitempty"` // zero = no automatic recovery
+}
+
+// values captured in previous steps can be referred to as {{name}} in URL, header values and body.
+// YAML tags because steps are too complex for CLI flags and are usually declared in a file.
+type HttpStep struct {
+	Name          string            `json:"name,omitempty" yaml:"name"`
+	Method        string            `json:"method,omitempty" yaml:"method"` // default GET
+	Url           string            `json:"url" yaml:"url"`
+	Headers       map[string]string `json:"headers,omitempty" yaml:"headers"`
+	Body          string            `json:"body,omitempty" yaml:"body"`
+	Captures      []HttpCapture     `json:"captures,omitempty" yaml:"captures"`
+	ExpectStatus  int               `json:"expect_status,omitempty" yaml:"expect_status"`   // zero = any non-error status (< 400)
+	Find          string            `json:"find,omitempty" yaml:"find"`                     // must be in body
+	ExpectHeaders map[string]string `json:"expect_headers,omitempty" yaml:"expect_headers"` // header must contain value
+}
+
+// exactly one source (header, JSON path or regex) must be given
+type HttpCapture struct {
+	Name     string `json:"name" yaml:"name"`
+	Header   string `json:"header,omitempty" yaml:"header"`
+	JsonPath string `json:"json_path,omitempty" yaml:"json_path"` // "data.items.0.id"
+	Regex    string `json:"regex,omitempty" yaml:"regex"`         // from body. first group if it has one
+}
+
+// each item is checked and all violations are reported together
+type HttpsAuditPolicy struct {
+	RequireHeaders  []string `json:"require_headers,omitempty" yaml:"require_headers"`     // "Name" (must be present) or "Name: value" (must contain value)
+	RedirectToHttps bool     `json:"redirect_to_https,omitempty" yaml:"redirect_to_https"` // plain-http URL must redirect to https://
+	HttpUrl         string   `json:"http_url,omitempty" yaml:"http_url"`                   // plain-http URL. "" = target with http:// (and default port)
+	MinTlsVersion   string   `json:"min_tls_version,omitempty" yaml:"min_tls_version"`     // "1.2". server must not accept older ones
+	CertHostname    bool     `json:"cert_hostname,omitempty" yaml:"cert_hostname"`         // certificate must be valid for target's hostname
+}
+
+// ------
+
+type MonitorCreated struct {
+	meta    ehevent.EventMeta
+	Id      string
+	Enabled bool
+	Config  MonitorConfig
+}
+
+func (e *MonitorCreated) MetaType() string         { return "MonitorCreated" }
+func (e *MonitorCreated) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewMonitorCreated(
+	id string,
+	enabled bool,
+	config MonitorConfig,
+	meta ehevent.EventMeta,
+) *MonitorCreated {
+	return &MonitorCreated{
+		meta:    meta,
+		Id:      id,
+		Enabled: enabled,
+		Config:  config,
+	}
+}
+
+// ------
+
+type MonitorEnabledUpdated struct {
+	meta    ehevent.EventMeta
+	Id      string
+	Enabled bool
+}
+
+func (e *MonitorEnabledUpdated) MetaType() string         { return "MonitorEnabledUpdated" }
+func (e *MonitorEnabledUpdated) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewMonitorEnabledUpdated(
+	id string,
+	enabled bool,
+	meta ehevent.EventMeta,
+) *MonitorEnabledUpdated {
+	return &MonitorEnabledUpdated{
+		meta:    meta,
+		Id:      id,
+		Enabled: enabled,
+	}
+}
+
+// ------
+
+// replaces monitor's config. ID and history are retained
+type MonitorUpdated struct {
+	meta   ehevent.EventMeta
+	Id     string
+	Config MonitorConfig
+}
+
+func (e *MonitorUpdated) MetaType() string         { return "MonitorUpdated" }
+func (e *MonitorUpdated) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewMonitorUpdated(
+	id string,
+	config MonitorConfig,
+	meta ehevent.EventMeta,
+) *MonitorUpdated {
+	return &MonitorUpdated{
+		meta:   meta,
+		Id:     id,
+		Config: config,
+	}
+}
+
+// ------
+
+type MonitorDeleted struct {
+	meta ehevent.EventMeta
+	Id   string
+}
+
+func (e *MonitorDeleted) MetaType() string         { return "MonitorDeleted" }
+func (e *MonitorDeleted) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewMonitorDeleted(
+	id string,
+	meta ehevent.EventMeta,
+) *MonitorDeleted {
+	return &MonitorDeleted{
+		meta: meta,
+		Id:   id,
+	}
+}
+
+// ------
+
+// scheduler or an agent ran checks for these monitors (event timestamp is the time of the run).
+// agents report even if they had nothing to check, so this also tells that an agent is alive.
+type MonitorsChecked struct {
+	meta    ehevent.EventMeta
+	Results []MonitorCheckResult
+	Agent   string // "" = scheduler
+}
+
+type MonitorCheckResult struct {
+	Id         string
+	Ok         bool
+	LatencyMs  int
+	StatusCode int    // 0 if not applicable for the kind, or we didn't get a response
+	Location   string // scheduler's region or agent's name. "" in events written before locations
+	Error      string // only for failures
+	// domain_expiry: set when the expiry was looked up (instead of using the cached one).
+	// zero if the lookup failed
+	DomainExpires *time.Time `json:",omitempty"`
+}
+
+func (e *MonitorsChecked) MetaType() string         { return "MonitorsChecked" }
+func (e *MonitorsChecked) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewMonitorsChecked(
+	results []MonitorCheckResult,
+	agent string,
+	meta ehevent.EventMeta,
+) *MonitorsChecked {
+	return &MonitorsChecked{
+		meta:    meta,
+		Results: results,
+		Agent:   agent,
+	}
+}
+
+// ------
+
 type DeadMansSwitchCreated struct {
 	meta    ehevent.EventMeta
 	Subject string
@@ -186,6 +443,8 @@ type DeadMansSwitchCheckin struct {
 	meta    ehevent.EventMeta
 	Subject string
 	Ttl     time.Time
+	Message string             `json:",omitempty"` // e.g. "backed up 42 GB in 17m"
+	Fields  map[string]float64 `json:",omitempty"` // e.g. {"size_gb": 42}
 }
 
 func (e *DeadMansSwitchCheckin) MetaType() string         { return "DeadMansSwitchCheckin" }
@@ -194,12 +453,175 @@ func (e *DeadMansSwitchCheckin) Meta() *ehevent.EventMeta { return &e.meta }
 func NewDeadMansSwitchCheckin(
 	subject string,
 	ttl time.Time,
+	message string,
+	fields map[string]float64,
 	meta ehevent.EventMeta,
 ) *DeadMansSwitchCheckin {
 	return &DeadMansSwitchCheckin{
 		meta:    meta,
 		Subject: subject,
 		Ttl:     ttl,
+		Message: message,
+		Fields:  fields,
+	}
+}
+
+// ------
+
+// switch with a schedule gets its next deadline from the schedule (instead of check-in's TTL)
+type DeadMansSwitchScheduleUpdated struct {
+	meta     ehevent.EventMeta
+	Subject  string
+	Schedule string        // cron expression. "" = no schedule
+	Grace    time.Duration // how late after scheduled time the check-in can come
+	Ttl      time.Time     // deadline according to new schedule
+}
+
+func (e *DeadMansSwitchScheduleUpdated) MetaType() string         { return "DeadMansSwitchScheduleUpdated" }
+func (e *DeadMansSwitchScheduleUpdated) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeadMansSwitchScheduleUpdated(
+	subject string,
+	schedule string,
+	grace time.Duration,
+	ttl time.Time,
+	meta ehevent.EventMeta,
+) *DeadMansSwitchScheduleUpdated {
+	return &DeadMansSwitchScheduleUpdated{
+		meta:     meta,
+		Subject:  subject,
+		Schedule: schedule,
+		Grace:    grace,
+		Ttl:      ttl,
+	}
+}
+
+// ------
+
+// job started. next check-in completes the run, which must happen within MaxRuntime
+type DeadMansSwitchRunStarted struct {
+	meta       ehevent.EventMeta
+	Subject    string
+	MaxRuntime time.Duration
+}
+
+func (e *DeadMansSwitchRunStarted) MetaType() string         { return "DeadMansSwitchRunStarted" }
+func (e *DeadMansSwitchRunStarted) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeadMansSwitchRunStarted(
+	subject string,
+	maxRuntime time.Duration,
+	meta ehevent.EventMeta,
+) *DeadMansSwitchRunStarted {
+	return &DeadMansSwitchRunStarted{
+		meta:       meta,
+		Subject:    subject,
+		MaxRuntime: maxRuntime,
+	}
+}
+
+// ------
+
+// job knows it failed. deadline is left as is, so a later successful check-in acks the alert.
+type DeadMansSwitchFailureReported struct {
+	meta    ehevent.EventMeta
+	Subject string
+	Message string `json:",omitempty"` // e.g. "exit code 1: disk full"
+}
+
+func (e *DeadMansSwitchFailureReported) MetaType() string         { return "DeadMansSwitchFailureReported" }
+func (e *DeadMansSwitchFailureReported) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeadMansSwitchFailureReported(
+	subject string,
+	message string,
+	meta ehevent.EventMeta,
+) *DeadMansSwitchFailureReported {
+	return &DeadMansSwitchFailureReported{
+		meta:    meta,
+		Subject: subject,
+		Message: message,
+	}
+}
+
+// ------
+
+// switch doesn't alert while paused (e.g. job temporarily stopped)
+type DeadMansSwitchPaused struct {
+	meta    ehevent.EventMeta
+	Subject string
+	Until   *time.Time `json:",omitempty"` // auto-resume. nil = until resumed
+	Ttl     time.Time  // deadline after auto-resume. zero = unchanged (when no auto-resume)
+}
+
+func (e *DeadMansSwitchPaused) MetaType() string         { return "DeadMansSwitchPaused" }
+func (e *DeadMansSwitchPaused) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeadMansSwitchPaused(
+	subject string,
+	until *time.Time,
+	ttl time.Time,
+	meta ehevent.EventMeta,
+) *DeadMansSwitchPaused {
+	return &DeadMansSwitchPaused{
+		meta:    meta,
+		Subject: subject,
+		Until:   until,
+		Ttl:     ttl,
+	}
+}
+
+// ------
+
+// deadline is moved, since it usually passed during the pause
+type DeadMansSwitchResumed struct {
+	meta    ehevent.EventMeta
+	Subject string
+	Ttl     time.Time
+}
+
+func (e *DeadMansSwitchResumed) MetaType() string         { return "DeadMansSwitchResumed" }
+func (e *DeadMansSwitchResumed) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeadMansSwitchResumed(
+	subject string,
+	ttl time.Time,
+	meta ehevent.EventMeta,
+) *DeadMansSwitchResumed {
+	return &DeadMansSwitchResumed{
+		meta:    meta,
+		Subject: subject,
+		Ttl:     ttl,
+	}
+}
+
+// ------
+
+// descriptive fields, shown in alerts so whoever gets paged knows what the job is about
+type DeadMansSwitchMetadataUpdated struct {
+	meta        ehevent.EventMeta
+	Subject     string
+	Description string `json:",omitempty"` // e.g. "Nightly backup of customer database"
+	Owner       string `json:",omitempty"` // e.g. "ops-team@example.com"
+	Runbook     string `json:",omitempty"` // URL
+}
+
+func (e *DeadMansSwitchMetadataUpdated) MetaType() string         { return "DeadMansSwitchMetadataUpdated" }
+func (e *DeadMansSwitchMetadataUpdated) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeadMansSwitchMetadataUpdated(
+	subject string,
+	description string,
+	owner string,
+	runbook string,
+	meta ehevent.EventMeta,
+) *DeadMansSwitchMetadataUpdated {
+	return &DeadMansSwitchMetadataUpdated{
+		meta:        meta,
+		Subject:     subject,
+		Description: description,
+		Owner:       owner,
+		Runbook:     runbook,
 	}
 }
 
@@ -222,3 +644,47 @@ func NewDeadMansSwitchDeleted(
 		Subject: subject,
 	}
 }
+
+// ------
+
+// agent runs checks from inside a private network and reports the results over the REST API
+type AgentCreated struct {
+	meta      ehevent.EventMeta
+	Name      string
+	TokenHash string // SHA-256 of the agent's token (hex)
+}
+
+func (e *AgentCreated) MetaType() string         { return "AgentCreated" }
+func (e *AgentCreated) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewAgentCreated(
+	name string,
+	tokenHash string,
+	meta ehevent.EventMeta,
+) *AgentCreated {
+	return &AgentCreated{
+		meta:      meta,
+		Name:      name,
+		TokenHash: tokenHash,
+	}
+}
+
+// ------
+
+type AgentDeleted struct {
+	meta ehevent.EventMeta
+	Name string
+}
+
+func (e *AgentDeleted) MetaType() string         { return "AgentDeleted" }
+func (e *AgentDeleted) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewAgentDeleted(
+	name string,
+	meta ehevent.EventMeta,
+) *AgentDeleted {
+	return &AgentDeleted{
+		meta: meta,
+		Name: name,
+	}
+}
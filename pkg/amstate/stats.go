@@ -0,0 +1,164 @@
+package amstate
+
+// Check history of HTTP monitors is kept as rollups so that the state (and its snapshot)
+// stays compact: hourly rollups for the last day and daily rollups for the last month.
+
+import (
+	"time"
+
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
+)
+
+const (
+	hourlyRollupsRetention = 25 * time.Hour      // 24h + the hour in progress
+	dailyRollupsRetention  = 31 * 24 * time.Hour // 30d + the day in progress
+)
+
+// upper bounds (inclusive) of latency histogram buckets. the last bucket catches everything
+// above the highest bound
+var latencyBucketsMs = []int{100, 250, 500, 750, 1000, 1500, 2500, 5000, 10000, 30000, 60000}
+
+type CheckRollup struct {
+	Start            time.Time `json:"start"`
+	Checks           int       `json:"checks"`
+	Failures         int       `json:"failures"`
+	LatencyHistogram []int     `json:"latency_histogram"` // only successful checks. len = len(latencyBucketsMs)+1
+}
+
+type MonitorHistory struct {
+	Hourly []CheckRollup `json:"hourly"`
+	Daily  []CheckRollup `json:"daily"`
+}
+
+type UptimeStats struct {
+	Window     time.Duration
+	Checks     int
+	Failures   int
+	Uptime     float64       // percentage. 100 if no checks
+	LatencyP50 time.Duration // approximation (upper bound of histogram bucket)
+	LatencyP95 time.Duration // approximation (upper bound of histogram bucket)
+}
+
+// computes stats for given window (looking back from now). windows up to two days use the
+// hourly rollups, longer windows use the daily rollups.
+func (h MonitorHistory) Stats(window time.Duration, now time.Time) UptimeStats {
+	rollups, granularity := h.Daily, 24*time.Hour
+	if window <= 48*time.Hour {
+		rollups, granularity = h.Hourly, time.Hour
+	}
+
+	windowStart := now.Add(-window).Truncate(granularity)
+
+	stats := UptimeStats{
+		Window: window,
+		Uptime: 100,
+	}
+
+	latencies := make([]int, len(latencyBucketsMs)+1)
+
+	for _, rollup := range rollups {
+		if rollup.Start.Before(windowStart) || rollup.Start.After(now) {
+			continue
+		}
+
+		stats.Checks += rollup.Checks
+		stats.Failures += rollup.Failures
+
+		for i, count := range rollup.LatencyHistogram {
+			latencies[i] += count
+		}
+	}
+
+	if stats.Checks > 0 {
+		stats.Uptime = float64(stats.Checks-stats.Failures) / float64(stats.Checks) * 100
+	}
+
+	stats.LatencyP50 = latencyPercentile(latencies, 0.50)
+	stats.LatencyP95 = latencyPercentile(latencies, 0.95)
+
+	return stats
+}
+
+func (h *MonitorHistory) record(result amdomain.MonitorCheckResult, ts time.Time) {
+	h.Hourly = recordIntoRollups(h.Hourly, result, ts.Truncate(time.Hour), ts.Add(-hourlyRollupsRetention))
+	h.Daily = recordIntoRollups(h.Daily, result, ts.Truncate(24*time.Hour), ts.Add(-dailyRollupsRetention))
+}
+
+// deep copy
+func copyRollups(rollups []CheckRollup) []CheckRollup {
+	copied := make([]CheckRollup, len(rollups))
+	for i, rollup := range rollups {
+		copied[i] = rollup
+		copied[i].LatencyHistogram = append([]int{}, rollup.LatencyHistogram...)
+	}
+
+	return copied
+}
+
+// rollups are in chronological order
+func recordIntoRollups(
+	rollups []CheckRollup,
+	result amdomain.MonitorCheckResult,
+	rollupStart time.Time,
+	retainAfter time.Time,
+) []CheckRollup {
+	// expire old rollups
+	for len(rollups) > 0 && rollups[0].Start.Before(retainAfter) {
+		rollups = rollups[1:]
+	}
+
+	if len(rollups) == 0 || !rollups[len(rollups)-1].Start.Equal(rollupStart) {
+		rollups = append(rollups, CheckRollup{
+			Start:            rollupStart,
+			LatencyHistogram: make([]int, len(latencyBucketsMs)+1),
+		})
+	}
+
+	current := &rollups[len(rollups)-1]
+
+	current.Checks++
+
+	if result.Ok {
+		current.LatencyHistogram[latencyBucketIdx(result.LatencyMs)]++
+	} else {
+		current.Failures++
+	}
+
+	return rollups
+}
+
+func latencyBucketIdx(latencyMs int) int {
+	for i, upperBound := range latencyBucketsMs {
+		if latencyMs <= upperBound {
+			return i
+		}
+	}
+
+	return len(latencyBucketsMs) // overflow bucket
+}
+
+func latencyPercentile(histogram []int, percentile float64) time.Duration {
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	cumulative := 0
+	for i, count := range histogram {
+		cumulative += count
+
+		if float64(cumulative) >= percentile*float64(total) {
+			if i == len(latencyBucketsMs) { // overflow bucket has no upper bound
+				i--
+			}
+
+			return time.Duration(latencyBucketsMs[i]) * time.Millisecond
+		}
+	}
+
+	panic("should not be reached")
+}
@@ -22,8 +22,10 @@ const (
 func newStateFormat() stateFormat {
 	return stateFormat{
 		ActiveAlerts:     map[string]Alert{},
-		HttpMonitors:     map[string]HttpMonitor{},
+		Monitors:         map[string]Monitor{},
 		DeadMansSwitches: map[string]DeadMansSwitch{},
+		MonitorHistories: map[string]*MonitorHistory{},
+		Agents:           map[string]Agent{},
 	}
 }
 
@@ -54,7 +56,7 @@ func (s *Store) InstallSnapshot(snap *ehreader.Snapshot) error {
 	defer s.mu.Unlock()
 
 	s.version = snap.Cursor
-	s.state = stateFormat{}
+	s.state = newStateFormat() // so maps missing from older snapshots get initialized
 
 	return json.Unmarshal(snap.Data, &s.state)
 }
@@ -85,12 +87,12 @@ func (s *Store) ActiveAlerts() []Alert {
 	return alerts
 }
 
-func (s *Store) HttpMonitors() []HttpMonitor {
+func (s *Store) Monitors() []Monitor {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	monitors := []HttpMonitor{}
-	for _, alert := range s.state.HttpMonitors {
+	monitors := []Monitor{}
+	for _, alert := range s.state.Monitors {
 		monitors = append(monitors, alert)
 	}
 
@@ -99,6 +101,23 @@ func (s *Store) HttpMonitors() []HttpMonitor {
 	return monitors
 }
 
+// returns empty history if monitor has no check results
+func (s *Store) MonitorHistory(id string) MonitorHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, found := s.state.MonitorHistories[id]
+	if !found {
+		return MonitorHistory{}
+	}
+
+	// copy, since rollups (and their histograms) get mutated in-place
+	return MonitorHistory{
+		Hourly: copyRollups(history.Hourly),
+		Daily:  copyRollups(history.Daily),
+	}
+}
+
 func (s *Store) DeadMansSwitches() []DeadMansSwitch {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -115,6 +134,20 @@ func (s *Store) DeadMansSwitches() []DeadMansSwitch {
 	return deadMansSwitches
 }
 
+func (s *Store) Agents() []Agent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agents := []Agent{}
+	for _, agent := range s.state.Agents {
+		agents = append(agents, agent)
+	}
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+
+	return agents
+}
+
 func (s *Store) LastUnnoticedAlertsNotified() time.Time {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -152,20 +185,59 @@ func (s *Store) processEvent(ev ehevent.Event) error {
 		}
 	case *amdomain.AlertAcknowledged:
 		delete(s.state.ActiveAlerts, e.Id)
-	case *amdomain.HttpMonitorCreated:
-		s.state.HttpMonitors[e.Id] = HttpMonitor{
+	case *amdomain.MonitorCreated:
+		s.state.Monitors[e.Id] = Monitor{
+			Id:            e.Id,
+			Created:       e.Meta().Timestamp,
+			Enabled:       e.Enabled,
+			MonitorConfig: e.Config,
+		}
+	case *amdomain.HttpMonitorCreated: // legacy
+		s.state.Monitors[e.Id] = Monitor{
 			Id:      e.Id,
 			Created: e.Meta().Timestamp,
 			Enabled: e.Enabled,
-			Url:     e.Url,
-			Find:    e.Find,
-		}
-	case *amdomain.HttpMonitorEnabledUpdated:
-		mon := s.state.HttpMonitors[e.Id]
-		mon.Enabled = e.Enabled
-		s.state.HttpMonitors[e.Id] = mon
-	case *amdomain.HttpMonitorDeleted:
-		delete(s.state.HttpMonitors, e.Id)
+			MonitorConfig: amdomain.MonitorConfig{
+				Kind:                  amdomain.MonitorKindHttp,
+				Target:                e.Url,
+				Find:                  e.Find,
+				Interval:              e.Interval,
+				Timeout:               e.Timeout,
+				AlertAfterFailures:    e.AlertAfterFailures,
+				RecoverAfterSuccesses: e.RecoverAfterSuccesses,
+			},
+		}
+	case *amdomain.MonitorEnabledUpdated:
+		s.monitorEnabledUpdated(e.Id, e.Enabled)
+	case *amdomain.HttpMonitorEnabledUpdated: // legacy
+		s.monitorEnabledUpdated(e.Id, e.Enabled)
+	case *amdomain.MonitorUpdated:
+		mon, found := s.state.Monitors[e.Id]
+		if !found { // update raced with a delete. don't resurrect as a ghost monitor
+			break
+		}
+		if mon.Target != e.Config.Target { // cached lookup was for another domain
+			mon.DomainExpiry = nil
+		}
+		mon.MonitorConfig = e.Config
+		s.state.Monitors[e.Id] = mon
+	case *amdomain.MonitorDeleted:
+		s.monitorDeleted(e.Id)
+	case *amdomain.HttpMonitorDeleted: // legacy
+		s.monitorDeleted(e.Id)
+	case *amdomain.MonitorsChecked:
+		s.monitorsChecked(e.Results, e.Meta().Timestamp)
+
+		if agent, found := s.state.Agents[e.Agent]; found {
+			agent.LastReported = e.Meta().Timestamp
+			s.state.Agents[e.Agent] = agent
+		}
+	case *amdomain.HttpMonitorsChecked: // legacy
+		for _, id := range e.Ids {
+			s.monitorChecked(id, e.Meta().Timestamp)
+		}
+
+		s.monitorsChecked(e.Results, e.Meta().Timestamp)
 	case *amdomain.DeadMansSwitchCreated:
 		s.state.DeadMansSwitches[e.Subject] = DeadMansSwitch{
 			Subject: e.Subject,
@@ -174,9 +246,63 @@ func (s *Store) processEvent(ev ehevent.Event) error {
 	case *amdomain.DeadMansSwitchCheckin:
 		dms := s.state.DeadMansSwitches[e.Subject]
 		dms.Ttl = e.Ttl
+		dms.History = appendDeadMansSwitchHistory(dms.History, DeadMansSwitchCheckin{
+			Time:     e.Meta().Timestamp,
+			Message:  e.Message,
+			Fields:   e.Fields,
+			Duration: dms.completeRun(e.Meta().Timestamp),
+		})
+		s.state.DeadMansSwitches[e.Subject] = dms
+	case *amdomain.DeadMansSwitchFailureReported:
+		s.state.DeadMansSwitches[e.Subject] = s.state.DeadMansSwitches[e.Subject].WithFailureReported(
+			e.Message,
+			e.Meta().Timestamp)
+	case *amdomain.DeadMansSwitchRunStarted:
+		dms := s.state.DeadMansSwitches[e.Subject]
+		dms.Run = &DeadMansSwitchRun{
+			Started:    e.Meta().Timestamp,
+			MaxRuntime: e.MaxRuntime,
+		}
+		s.state.DeadMansSwitches[e.Subject] = dms
+	case *amdomain.DeadMansSwitchScheduleUpdated:
+		dms := s.state.DeadMansSwitches[e.Subject]
+		dms.Schedule = e.Schedule
+		dms.Grace = e.Grace
+		dms.Ttl = e.Ttl
+		s.state.DeadMansSwitches[e.Subject] = dms
+	case *amdomain.DeadMansSwitchPaused:
+		dms := s.state.DeadMansSwitches[e.Subject]
+		dms.Paused = true
+		dms.PausedUntil = e.Until
+		// switch doesn't alert while paused, so we can move the deadline already
+		if !e.Ttl.IsZero() {
+			dms.Ttl = e.Ttl
+		}
+		s.state.DeadMansSwitches[e.Subject] = dms
+	case *amdomain.DeadMansSwitchResumed:
+		dms := s.state.DeadMansSwitches[e.Subject]
+		dms.Paused = false
+		dms.PausedUntil = nil
+		if !e.Ttl.IsZero() {
+			dms.Ttl = e.Ttl
+		}
+		s.state.DeadMansSwitches[e.Subject] = dms
+	case *amdomain.DeadMansSwitchMetadataUpdated:
+		dms := s.state.DeadMansSwitches[e.Subject]
+		dms.Description = e.Description
+		dms.Owner = e.Owner
+		dms.Runbook = e.Runbook
 		s.state.DeadMansSwitches[e.Subject] = dms
 	case *amdomain.DeadMansSwitchDeleted:
 		delete(s.state.DeadMansSwitches, e.Subject)
+	case *amdomain.AgentCreated:
+		s.state.Agents[e.Name] = Agent{
+			Name:      e.Name,
+			TokenHash: e.TokenHash,
+			Created:   e.Meta().Timestamp,
+		}
+	case *amdomain.AgentDeleted:
+		delete(s.state.Agents, e.Name)
 	case *amdomain.UnnoticedAlertsNotified:
 		s.state.LastUnnoticedAlertsNotified = e.Meta().Timestamp
 	default:
@@ -186,6 +312,120 @@ func (s *Store) processEvent(ev ehevent.Event) error {
 	return nil
 }
 
+// check-in (successful or not) completes the run. returns its duration (0 if no run in progress)
+func (d *DeadMansSwitch) completeRun(now time.Time) time.Duration {
+	if d.Run == nil {
+		return 0
+	}
+
+	duration := now.Sub(d.Run.Started)
+	d.Run = nil
+	return duration
+}
+
+// switch as it is after the failure report is applied. for alerting about the failure before
+// the event has been projected.
+func (d DeadMansSwitch) WithFailureReported(message string, ts time.Time) DeadMansSwitch {
+	d.History = appendDeadMansSwitchHistory(d.History, DeadMansSwitchCheckin{
+		Time:     ts,
+		Message:  message,
+		Duration: d.completeRun(ts),
+		Failed:   true,
+	})
+
+	return d
+}
+
+// returns new slice with at most DeadMansSwitchHistoryLength most recent check-ins
+func appendDeadMansSwitchHistory(history []DeadMansSwitchCheckin, checkin DeadMansSwitchCheckin) []DeadMansSwitchCheckin {
+	if len(history) >= DeadMansSwitchHistoryLength {
+		history = history[len(history)-DeadMansSwitchHistoryLength+1:]
+	}
+
+	return append(append([]DeadMansSwitchCheckin{}, history...), checkin)
+}
+
+func (s *Store) monitorEnabledUpdated(id string, enabled bool) {
+	mon := s.state.Monitors[id]
+	mon.Enabled = enabled
+	s.state.Monitors[id] = mon
+}
+
+func (s *Store) monitorDeleted(id string) {
+	delete(s.state.Monitors, id)
+	delete(s.state.MonitorHistories, id)
+}
+
+func (s *Store) monitorsChecked(results []amdomain.MonitorCheckResult, ts time.Time) {
+	for _, result := range results {
+		if !s.monitorChecked(result.Id, ts) {
+			continue
+		}
+
+		mon := s.state.Monitors[result.Id]
+
+		if result.Location != "" { // not in events written before locations
+			latestByLocation := map[string]LocationResult{}
+			for location, latest := range mon.LatestByLocation {
+				latestByLocation[location] = latest
+			}
+			latestByLocation[result.Location] = LocationResult{
+				Location: result.Location,
+				Ok:       result.Ok,
+				Error:    result.Error,
+				Checked:  ts,
+			}
+			mon.LatestByLocation = latestByLocation
+		}
+
+		if result.DomainExpires != nil {
+			failedLookups := 0
+			if result.DomainExpires.IsZero() { // lookup failed
+				failedLookups = 1
+				if mon.DomainExpiry != nil {
+					failedLookups += mon.DomainExpiry.FailedLookups
+				}
+			}
+
+			mon.DomainExpiry = &DomainExpiry{
+				Expires:       *result.DomainExpires,
+				LookedUp:      ts,
+				FailedLookups: failedLookups,
+			}
+		}
+
+		if result.Ok {
+			mon.ConsecutiveSuccesses++
+			mon.ConsecutiveFailures = 0
+		} else {
+			mon.ConsecutiveFailures++
+			mon.ConsecutiveSuccesses = 0
+		}
+		s.state.Monitors[result.Id] = mon
+
+		history, found := s.state.MonitorHistories[result.Id]
+		if !found {
+			history = &MonitorHistory{}
+			s.state.MonitorHistories[result.Id] = history
+		}
+
+		history.record(result, ts)
+	}
+}
+
+// returns false if monitor was not found (= deleted while check was running)
+func (s *Store) monitorChecked(id string, ts time.Time) bool {
+	mon, found := s.state.Monitors[id]
+	if !found {
+		return false
+	}
+
+	mon.LastChecked = ts
+	s.state.Monitors[id] = mon
+
+	return true
+}
+
 type App struct {
 	State  *Store
 	Reader *ehreader.Reader
@@ -2,6 +2,9 @@ package amstate
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -73,40 +76,189 @@ func TestAlerts(t *testing.T) {
 	assert.Assert(t, len(app.State.ActiveAlerts()) == 1)
 }
 
-func TestHttpMonitors(t *testing.T) {
+func TestMonitors(t *testing.T) {
 	ctx := context.Background()
 
 	eventLog := ehreadertest.NewEventLog()
 	eventLog.AppendE(
 		testStreamName,
-		amdomain.NewHttpMonitorCreated(
+		amdomain.NewMonitorCreated(
 			"49365a17244e",
 			true,
-			"https://function61.com/",
-			"Welcome to the best page in the universe",
+			amdomain.MonitorConfig{
+				Kind:                  amdomain.MonitorKindHttp,
+				Target:                "https://function61.com/",
+				Find:                  "Welcome to the best page in the universe",
+				Interval:              5 * time.Minute,
+				AlertAfterFailures:    3,
+				RecoverAfterSuccesses: 2,
+			},
 			ehevent.MetaSystemUser(t0)))
 
 	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
 	assert.Ok(t, err)
 
-	assert.EqualJson(t, app.State.HttpMonitors()[0], `{
+	assert.EqualJson(t, app.State.Monitors()[0], `{
   "id": "49365a17244e",
   "created": "2020-02-20T14:02:00Z",
   "enabled": true,
-  "url": "https://function61.com/",
-  "find": "Welcome to the best page in the universe"
+  "kind": "http",
+  "target": "https://function61.com/",
+  "find": "Welcome to the best page in the universe",
+  "interval": 300000000000,
+  "alert_after_failures": 3,
+  "recover_after_successes": 2,
+  "last_checked": "0001-01-01T00:00:00Z"
 }`)
 
+	assert.Assert(t, app.State.Monitors()[0].GetTimeout() == DefaultMonitorTimeout)
+
 	eventLog.AppendE(
 		testStreamName,
-		amdomain.NewHttpMonitorEnabledUpdated(
+		amdomain.NewMonitorsChecked(
+			[]amdomain.MonitorCheckResult{
+				{Id: "49365a17244e", Ok: true, LatencyMs: 120, StatusCode: 200},
+				{Id: "idOfDeletedMonitor", Ok: false},
+			},
+			"",
+			ehevent.MetaSystemUser(t0.Add(10*time.Second))))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.EqualJson(t, app.State.Monitors()[0].LastChecked, `"2020-02-20T14:02:10Z"`)
+	assert.Assert(t, len(app.State.Monitors()) == 1)
+	assert.Assert(t, app.State.MonitorHistory("49365a17244e").Stats(24*time.Hour, t0).Checks == 1)
+	assert.Assert(t, len(app.State.MonitorHistory("idOfDeletedMonitor").Hourly) == 0)
+
+	// returned history doesn't share histograms with the projection
+	history := app.State.MonitorHistory("49365a17244e")
+	history.Hourly[0].LatencyHistogram[0] = 42
+	assert.Assert(t, app.State.MonitorHistory("49365a17244e").Hourly[0].LatencyHistogram[0] != 42)
+	assert.Assert(t, app.State.Monitors()[0].ConsecutiveSuccesses == 1)
+
+	checked := func(ok bool) {
+		eventLog.AppendE(
+			testStreamName,
+			amdomain.NewMonitorsChecked(
+				[]amdomain.MonitorCheckResult{{Id: "49365a17244e", Ok: ok}},
+				"",
+				ehevent.MetaSystemUser(t0.Add(1*time.Minute))))
+
+		assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+	}
+
+	checked(false)
+	checked(false)
+
+	assert.Assert(t, app.State.Monitors()[0].ConsecutiveFailures == 2)
+	assert.Assert(t, app.State.Monitors()[0].ConsecutiveSuccesses == 0)
+
+	checked(true)
+
+	assert.Assert(t, app.State.Monitors()[0].ConsecutiveFailures == 0)
+	assert.Assert(t, app.State.Monitors()[0].ConsecutiveSuccesses == 1)
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewMonitorEnabledUpdated(
 			"49365a17244e",
 			false,
 			ehevent.MetaSystemUser(t0)))
 
 	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
 
-	assert.Assert(t, !app.State.HttpMonitors()[0].Enabled)
+	assert.Assert(t, !app.State.Monitors()[0].Enabled)
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewMonitorUpdated(
+			"49365a17244e",
+			amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttp,
+				Target: "https://function61.com/about",
+				Find:   "About us",
+			},
+			ehevent.MetaSystemUser(t0)))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.EqualString(t, app.State.Monitors()[0].Subject(), "https://function61.com/about")
+	assert.Assert(t, app.State.Monitors()[0].GetInterval() == DefaultMonitorInterval)
+	// update only touches config
+	assert.Assert(t, !app.State.Monitors()[0].Enabled)
+	assert.Assert(t, app.State.Monitors()[0].ConsecutiveSuccesses == 1)
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewMonitorDeleted(
+			"49365a17244e",
+			ehevent.MetaSystemUser(t0)))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.Assert(t, len(app.State.Monitors()) == 0)
+	assert.Assert(t, len(app.State.MonitorHistory("49365a17244e").Hourly) == 0)
+
+	// update that raced with the delete
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewMonitorUpdated(
+			"49365a17244e",
+			amdomain.MonitorConfig{
+				Kind:   amdomain.MonitorKindHttp,
+				Target: "https://function61.com/",
+			},
+			ehevent.MetaSystemUser(t0)))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.Assert(t, len(app.State.Monitors()) == 0)
+}
+
+func TestLegacyHttpMonitorEvents(t *testing.T) {
+	ctx := context.Background()
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewHttpMonitorCreated(
+			"49365a17244e",
+			true,
+			"https://function61.com/",
+			"Welcome to the best page in the universe",
+			0,
+			0,
+			0,
+			0,
+			ehevent.MetaSystemUser(t0)))
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewHttpMonitorsChecked(
+			[]amdomain.MonitorCheckResult{{Id: "49365a17244e", Ok: false}},
+			ehevent.MetaSystemUser(t0.Add(1*time.Minute))))
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewHttpMonitorEnabledUpdated(
+			"49365a17244e",
+			false,
+			ehevent.MetaSystemUser(t0.Add(2*time.Minute))))
+
+	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
+	assert.Ok(t, err)
+
+	assert.EqualJson(t, app.State.Monitors(), `[
+  {
+    "id": "49365a17244e",
+    "created": "2020-02-20T14:02:00Z",
+    "enabled": false,
+    "kind": "http",
+    "target": "https://function61.com/",
+    "find": "Welcome to the best page in the universe",
+    "last_checked": "2020-02-20T14:03:00Z",
+    "consecutive_failures": 1
+  }
+]`)
+	assert.EqualString(t, app.State.Monitors()[0].Subject(), "https://function61.com/")
 
 	eventLog.AppendE(
 		testStreamName,
@@ -116,7 +268,140 @@ func TestHttpMonitors(t *testing.T) {
 
 	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
 
-	assert.Assert(t, len(app.State.HttpMonitors()) == 0)
+	assert.Assert(t, len(app.State.Monitors()) == 0)
+}
+
+func TestMonitorSubject(t *testing.T) {
+	subject := func(kind amdomain.MonitorKind, target string, dnsRecordType string) string {
+		return Monitor{MonitorConfig: amdomain.MonitorConfig{
+			Kind:          kind,
+			Target:        target,
+			DnsRecordType: dnsRecordType,
+		}}.Subject()
+	}
+
+	assert.EqualString(t, subject(amdomain.MonitorKindHttp, "https://function61.com/", ""), "https://function61.com/")
+	assert.EqualString(t, subject(amdomain.MonitorKindTcp, "db.example.com:5432", ""), "tcp db.example.com:5432")
+	assert.EqualString(t, subject(amdomain.MonitorKindDns, "example.com", "MX"), "dns example.com MX")
+	assert.EqualString(t, subject(amdomain.MonitorKindGrpc, "api.example.com:443", ""), "grpc api.example.com:443")
+}
+
+func TestMonitorHistoryStats(t *testing.T) {
+	history := &MonitorHistory{}
+
+	record := func(ok bool, latencyMs int, ts time.Time) {
+		history.record(amdomain.MonitorCheckResult{Ok: ok, LatencyMs: latencyMs}, ts)
+	}
+
+	// 40 days of checks every 30 minutes. one failure every 10th day at noon
+	for ts := t0.Truncate(time.Hour).Add(-40 * 24 * time.Hour); ts.Before(t0.Truncate(time.Hour)); ts = ts.Add(30 * time.Minute) {
+		failure := ts.YearDay()%10 == 0 && ts.Hour() == 12 && ts.Minute() == 0
+
+		latencyMs := 80
+		if ts.Hour()%4 == 0 && ts.Minute() == 30 { // every eighth check is slow
+			latencyMs = 900
+		}
+
+		record(!failure, latencyMs, ts)
+	}
+
+	// retention keeps the projection compact
+	assert.Assert(t, len(history.Hourly) == 25)
+	assert.Assert(t, len(history.Daily) == 31)
+
+	statsFor := func(window time.Duration) string {
+		stats := history.Stats(window, t0)
+		return fmt.Sprintf(
+			"checks=%d failures=%d uptime=%.2f%% p50=%s p95=%s",
+			stats.Checks,
+			stats.Failures,
+			stats.Uptime,
+			stats.LatencyP50,
+			stats.LatencyP95)
+	}
+
+	assert.EqualString(t, statsFor(24*time.Hour), "checks=48 failures=0 uptime=100.00% p50=100ms p95=1s")
+	assert.EqualString(t, statsFor(7*24*time.Hour), "checks=364 failures=1 uptime=99.73% p50=100ms p95=1s")
+	assert.EqualString(t, statsFor(30*24*time.Hour), "checks=1468 failures=3 uptime=99.80% p50=100ms p95=1s")
+
+	noChecks := MonitorHistory{}.Stats(24*time.Hour, t0)
+	assert.Assert(t, noChecks.Checks == 0 && noChecks.Uptime == 100 && noChecks.LatencyP50 == 0)
+}
+
+func TestDueMonitors(t *testing.T) {
+	monitors := []Monitor{
+		{Id: "never checked", MonitorConfig: amdomain.MonitorConfig{Interval: 1 * time.Hour}},
+		{Id: "every minute", LastChecked: t0.Add(5 * time.Second)},
+		{Id: "every 5 min", MonitorConfig: amdomain.MonitorConfig{Interval: 5 * time.Minute}, LastChecked: t0.Add(3 * time.Second)},
+		{Id: "checked elsewhere", LastChecked: t0.Add(5 * time.Second), LatestByLocation: map[string]LocationResult{
+			"office": {Location: "office", Ok: true, Checked: t0.Add(5 * time.Second)},
+		}},
+	}
+
+	dueIdsAtT0Plus := func(plus time.Duration) string {
+		ids := []string{}
+		for _, monitor := range DueMonitors(monitors, "eu-west-1", t0.Add(plus)) {
+			ids = append(ids, monitor.Id)
+		}
+		return strings.Join(ids, ", ")
+	}
+
+	// "checked elsewhere" was checked from another location, but not from ours
+	assert.EqualString(t, dueIdsAtT0Plus(30*time.Second), "never checked, checked elsewhere")
+	// scheduler invoked a bit early compared to last run, but still on the next minute
+	assert.EqualString(t, dueIdsAtT0Plus(1*time.Minute+1*time.Second), "never checked, every minute, checked elsewhere")
+	assert.EqualString(t, dueIdsAtT0Plus(4*time.Minute+59*time.Second), "never checked, every minute, checked elsewhere")
+	assert.EqualString(t, dueIdsAtT0Plus(5*time.Minute), "never checked, every minute, every 5 min, checked elsewhere")
+}
+
+func TestQuorum(t *testing.T) {
+	monitor := Monitor{MonitorConfig: amdomain.MonitorConfig{
+		Quorum:       2,
+		QuorumWindow: 3 * time.Minute,
+	}}
+
+	results := func(results ...LocationResult) map[string]LocationResult {
+		byLocation := map[string]LocationResult{}
+		for _, result := range results {
+			byLocation[result.Location] = result
+		}
+		return byLocation
+	}
+
+	failingLocations := func(now time.Time) string {
+		locations := []string{}
+		for _, result := range monitor.FailingLocations(now) {
+			locations = append(locations, result.Location)
+		}
+		return strings.Join(locations, ", ")
+	}
+
+	// one location failing is not enough
+	monitor.LatestByLocation = results(
+		LocationResult{Location: "us-east-1", Ok: false, Error: "timeout", Checked: t0},
+		LocationResult{Location: "eu-west-1", Ok: true, Checked: t0},
+		LocationResult{Location: "office", Ok: true, Checked: t0})
+
+	assert.EqualString(t, failingLocations(t0), "us-east-1")
+	assert.Assert(t, !monitor.QuorumFailing(t0))
+
+	// second location agrees within window
+	monitor.LatestByLocation = results(
+		LocationResult{Location: "us-east-1", Ok: false, Error: "timeout", Checked: t0},
+		LocationResult{Location: "eu-west-1", Ok: false, Error: "timeout", Checked: t0.Add(2 * time.Minute)},
+		LocationResult{Location: "office", Ok: true, Checked: t0})
+
+	assert.EqualString(t, failingLocations(t0.Add(2*time.Minute)), "eu-west-1, us-east-1")
+	assert.Assert(t, monitor.QuorumFailing(t0.Add(2*time.Minute)))
+
+	// first failure is too old to count
+	assert.EqualString(t, failingLocations(t0.Add(3*time.Minute+1*time.Second)), "eu-west-1")
+	assert.Assert(t, !monitor.QuorumFailing(t0.Add(3*time.Minute+1*time.Second)))
+
+	// defaults: any location failing is enough, and window is two intervals
+	monitor.MonitorConfig = amdomain.MonitorConfig{Interval: 5 * time.Minute}
+	assert.Assert(t, monitor.QuorumFailing(t0.Add(12*time.Minute)))
+	assert.Assert(t, !monitor.QuorumFailing(t0.Add(12*time.Minute+1*time.Second)))
 }
 
 func TestDeadMansSwitches(t *testing.T) {
@@ -145,6 +430,8 @@ func TestDeadMansSwitches(t *testing.T) {
 		amdomain.NewDeadMansSwitchCheckin(
 			"Joonas checkins",
 			t0.Add(3*time.Hour),
+			"backed up 42 GB",
+			map[string]float64{"size_gb": 42},
 			ehevent.MetaSystemUser(t0)))
 
 	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
@@ -152,7 +439,16 @@ func TestDeadMansSwitches(t *testing.T) {
 	assert.EqualJson(t, app.State.DeadMansSwitches(), `[
   {
     "subject": "Joonas checkins",
-    "ttl": "2020-02-20T17:02:00Z"
+    "ttl": "2020-02-20T17:02:00Z",
+    "history": [
+      {
+        "time": "2020-02-20T14:02:00Z",
+        "message": "backed up 42 GB",
+        "fields": {
+          "size_gb": 42
+        }
+      }
+    ]
   }
 ]`)
 
@@ -173,6 +469,158 @@ func TestDeadMansSwitches(t *testing.T) {
 	assert.EqualJson(t, app.State.DeadMansSwitches(), `[]`)
 }
 
+func TestDeadMansSwitchHistoryLength(t *testing.T) {
+	ctx := context.Background()
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchCreated(
+			"backup",
+			t0.Add(1*time.Hour),
+			ehevent.MetaSystemUser(t0)))
+
+	for i := 1; i <= DeadMansSwitchHistoryLength+2; i++ {
+		eventLog.AppendE(
+			testStreamName,
+			amdomain.NewDeadMansSwitchCheckin(
+				"backup",
+				t0.Add(time.Duration(i+1)*time.Hour),
+				"",
+				map[string]float64{"run": float64(i)},
+				ehevent.MetaSystemUser(t0.Add(time.Duration(i)*time.Hour))))
+	}
+
+	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
+	assert.Ok(t, err)
+
+	history := app.State.DeadMansSwitches()[0].History
+	assert.Assert(t, len(history) == DeadMansSwitchHistoryLength)
+	assert.Assert(t, history[0].Fields["run"] == 3)
+	assert.Assert(t, history[DeadMansSwitchHistoryLength-1].Fields["run"] == float64(DeadMansSwitchHistoryLength+2))
+}
+
+func TestDeadMansSwitchRuns(t *testing.T) {
+	ctx := context.Background()
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchCreated(
+			"backup",
+			t0.Add(24*time.Hour),
+			ehevent.MetaSystemUser(t0)),
+		amdomain.NewDeadMansSwitchRunStarted(
+			"backup",
+			2*time.Hour,
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
+	assert.Ok(t, err)
+
+	assert.EqualJson(t, app.State.DeadMansSwitches(), `[
+  {
+    "subject": "backup",
+    "ttl": "2020-02-21T14:02:00Z",
+    "run": {
+      "started": "2020-02-20T14:02:00Z",
+      "max_runtime": 7200000000000
+    }
+  }
+]`)
+
+	switches := app.State.DeadMansSwitches()
+
+	assert.Assert(t, len(GetOverdueDeadMansSwitchRuns(switches, t0.Add(119*time.Minute))) == 0)
+	assert.Assert(t, len(GetOverdueDeadMansSwitchRuns(switches, t0.Add(2*time.Hour))) == 1)
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchCheckin(
+			"backup",
+			t0.Add(48*time.Hour),
+			"",
+			nil,
+			ehevent.MetaSystemUser(t0.Add(17*time.Minute))))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.EqualJson(t, app.State.DeadMansSwitches(), `[
+  {
+    "subject": "backup",
+    "ttl": "2020-02-22T14:02:00Z",
+    "history": [
+      {
+        "time": "2020-02-20T14:19:00Z",
+        "duration": 1020000000000
+      }
+    ]
+  }
+]`)
+
+	assert.Assert(t, len(GetOverdueDeadMansSwitchRuns(app.State.DeadMansSwitches(), t0.Add(3*time.Hour))) == 0)
+}
+
+func TestDeadMansSwitchPause(t *testing.T) {
+	ctx := context.Background()
+
+	resumeAt := t0.Add(48 * time.Hour)
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchCreated(
+			"backup",
+			t0.Add(1*time.Hour),
+			ehevent.MetaSystemUser(t0)),
+		amdomain.NewDeadMansSwitchCreated(
+			"report",
+			t0.Add(1*time.Hour),
+			ehevent.MetaSystemUser(t0)),
+		amdomain.NewDeadMansSwitchPaused(
+			"backup",
+			&resumeAt,
+			resumeAt.Add(1*time.Hour),
+			ehevent.MetaSystemUser(t0)),
+		amdomain.NewDeadMansSwitchPaused(
+			"report",
+			nil,
+			time.Time{},
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
+	assert.Ok(t, err)
+
+	expiredSubjects := func(now time.Time) string {
+		subjects := []string{}
+		for _, dms := range GetExpiredDeadMansSwitches(app.State.DeadMansSwitches(), now) {
+			subjects = append(subjects, dms.Subject)
+		}
+		sort.Strings(subjects)
+		return strings.Join(subjects, ", ")
+	}
+
+	assert.EqualString(t, expiredSubjects(t0.Add(2*time.Hour)), "")
+	// auto-resumed, but deadline was moved so it doesn't alert right away
+	assert.EqualString(t, expiredSubjects(t0.Add(48*time.Hour)), "")
+	assert.EqualString(t, expiredSubjects(t0.Add(49*time.Hour+time.Minute)), "backup")
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewDeadMansSwitchResumed(
+			"report",
+			t0.Add(4*time.Hour),
+			ehevent.MetaSystemUser(t0.Add(3*time.Hour))))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.EqualString(t, expiredSubjects(t0.Add(3*time.Hour)), "")
+	assert.EqualString(t, expiredSubjects(t0.Add(4*time.Hour+time.Minute)), "report")
+
+	report := FindDeadMansSwitchWithSubject("report", app.State.DeadMansSwitches())
+	assert.Assert(t, !report.Paused && report.PausedUntil == nil)
+}
+
 func TestGetUnnoticedAlerts(t *testing.T) {
 	ctx := context.Background()
 
@@ -212,3 +660,126 @@ func TestGetUnnoticedAlerts(t *testing.T) {
 
 	assert.EqualJson(t, app.State.LastUnnoticedAlertsNotified(), `"2020-02-20T14:02:00Z"`)
 }
+
+func TestAgents(t *testing.T) {
+	ctx := context.Background()
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewAgentCreated(
+			"office",
+			"dummyhash",
+			ehevent.MetaSystemUser(t0)))
+
+	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
+	assert.Ok(t, err)
+
+	silentAt := func(now time.Time) int {
+		return len(GetSilentAgents(app.State.Agents(), now))
+	}
+
+	// never reported => silence counted from creation
+	assert.Assert(t, silentAt(t0.Add(4*time.Minute)) == 0)
+	assert.Assert(t, silentAt(t0.Add(5*time.Minute)) == 1)
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewMonitorsChecked(
+			nil, // agents report even if they had nothing to check
+			"office",
+			ehevent.MetaSystemUser(t0.Add(10*time.Minute))))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.EqualJson(t, app.State.Agents(), `[
+  {
+    "name": "office",
+    "token_hash": "dummyhash",
+    "created": "2020-02-20T14:02:00Z",
+    "last_reported": "2020-02-20T14:12:00Z"
+  }
+]`)
+	assert.EqualString(t, app.State.Agents()[0].Subject(), "agent office")
+	assert.Assert(t, silentAt(t0.Add(14*time.Minute)) == 0)
+	assert.Assert(t, silentAt(t0.Add(15*time.Minute)) == 1)
+
+	eventLog.AppendE(
+		testStreamName,
+		amdomain.NewAgentDeleted(
+			"office",
+			ehevent.MetaSystemUser(t0.Add(20*time.Minute))))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	assert.Assert(t, FindAgentWithName("office", app.State.Agents()) == nil)
+}
+
+func TestDomainExpiryCache(t *testing.T) {
+	ctx := context.Background()
+
+	config := amdomain.MonitorConfig{
+		Kind:   amdomain.MonitorKindDomainExpiry,
+		Target: "https://example.com/",
+	}
+
+	expires := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorCreated("m1", true, config, ehevent.MetaSystemUser(t0)))
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorsChecked([]amdomain.MonitorCheckResult{
+		{Id: "m1", Ok: true, DomainExpires: &expires},
+	}, "", ehevent.MetaSystemUser(t0)))
+	// used the cached one => doesn't change when it was looked up
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorsChecked([]amdomain.MonitorCheckResult{
+		{Id: "m1", Ok: true},
+	}, "", ehevent.MetaSystemUser(t0.Add(1*time.Hour))))
+
+	app, err := LoadUntilRealtime(ctx, ehreader.NewTenantCtxWithSnapshots(ehreader.TenantId("42"), eventLog, ehreader.NewInMemSnapshotStore()), nil)
+	assert.Ok(t, err)
+
+	mon := *FindMonitorWithId("m1", app.State.Monitors())
+	assert.Assert(t, mon.DomainExpiry.LookedUp.Equal(t0))
+	assert.Assert(t, mon.CachedDomainExpiry(t0.Add(23*time.Hour)).Equal(expires))
+	assert.Assert(t, mon.CachedDomainExpiry(t0.Add(24*time.Hour)) == nil)
+
+	// changing other settings keeps the cache
+	config.ExpiryAlertDays = 14
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorUpdated("m1", config, ehevent.MetaSystemUser(t0.Add(2*time.Hour))))
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+	assert.Assert(t, FindMonitorWithId("m1", app.State.Monitors()).DomainExpiry != nil)
+
+	config.Target = "https://example.net/"
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorUpdated("m1", config, ehevent.MetaSystemUser(t0.Add(3*time.Hour))))
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+	assert.Assert(t, FindMonitorWithId("m1", app.State.Monitors()).DomainExpiry == nil)
+
+	// failed lookups back off
+	failedLookup := func(at time.Time) Monitor {
+		eventLog.AppendE(testStreamName, amdomain.NewMonitorsChecked([]amdomain.MonitorCheckResult{
+			{Id: "m1", Ok: false, Error: "registry down", DomainExpires: &time.Time{}},
+		}, "", ehevent.MetaSystemUser(at)))
+		assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+		return *FindMonitorWithId("m1", app.State.Monitors())
+	}
+
+	mon = failedLookup(t0.Add(4 * time.Hour))
+	assert.Assert(t, mon.CachedDomainExpiry(t0.Add(4*time.Hour+14*time.Minute)).IsZero())
+	assert.Assert(t, mon.CachedDomainExpiry(t0.Add(4*time.Hour+15*time.Minute)) == nil)
+
+	mon = failedLookup(t0.Add(5 * time.Hour))
+	assert.Assert(t, mon.DomainExpiry.FailedLookups == 2)
+	assert.Assert(t, mon.DomainExpiry.NextLookup().Equal(t0.Add(5*time.Hour+30*time.Minute)))
+
+	for i := 0; i < 10; i++ {
+		mon = failedLookup(t0.Add(6 * time.Hour))
+	}
+	assert.Assert(t, mon.DomainExpiry.NextLookup().Equal(t0.Add(30*time.Hour)))
+
+	// success resets the backoff
+	eventLog.AppendE(testStreamName, amdomain.NewMonitorsChecked([]amdomain.MonitorCheckResult{
+		{Id: "m1", Ok: true, DomainExpires: &expires},
+	}, "", ehevent.MetaSystemUser(t0.Add(7*time.Hour))))
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+	assert.Assert(t, FindMonitorWithId("m1", app.State.Monitors()).DomainExpiry.FailedLookups == 0)
+}
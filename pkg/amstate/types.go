@@ -1,15 +1,20 @@
 package amstate
 
 import (
+	"fmt"
 	"time"
+
+	"github.com/function61/lambda-alertmanager/pkg/amdomain"
 )
 
 // for snapshots
 type stateFormat struct {
-	LastUnnoticedAlertsNotified time.Time                 `json:"last_unnoticed_alerts_notified"`
-	ActiveAlerts                map[string]Alert          `json:"active_alerts"`
-	HttpMonitors                map[string]HttpMonitor    `json:"http_monitors"`
-	DeadMansSwitches            map[string]DeadMansSwitch `json:"dead_mans_switches"`
+	LastUnnoticedAlertsNotified time.Time                  `json:"last_unnoticed_alerts_notified"`
+	ActiveAlerts                map[string]Alert           `json:"active_alerts"`
+	Monitors                    map[string]Monitor         `json:"monitors"`
+	DeadMansSwitches            map[string]DeadMansSwitch  `json:"dead_mans_switches"`
+	MonitorHistories            map[string]*MonitorHistory `json:"monitor_histories"`
+	Agents                      map[string]Agent           `json:"agents"`
 }
 
 type Alert struct {
@@ -19,15 +24,240 @@ type Alert struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-type HttpMonitor struct {
+type Monitor struct {
 	Id      string    `json:"id"`
 	Created time.Time `json:"created"`
 	Enabled bool      `json:"enabled"`
-	Url     string    `json:"url"`
-	Find    string    `json:"find"`
+	amdomain.MonitorConfig
+	LastChecked          time.Time `json:"last_checked"`
+	ConsecutiveFailures  int       `json:"consecutive_failures,omitempty"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes,omitempty"`
+	// latest result from each location the monitor is checked from. replaced (not mutated)
+	// on update, since copies of the monitor share it
+	LatestByLocation map[string]LocationResult `json:"latest_by_location,omitempty"`
+	DomainExpiry     *DomainExpiry             `json:"domain_expiry,omitempty"` // cached lookup of domain_expiry monitor
+}
+
+type DomainExpiry struct {
+	Expires       time.Time `json:"expires"` // zero if lookup failed
+	LookedUp      time.Time `json:"looked_up"`
+	FailedLookups int       `json:"failed_lookups,omitempty"` // consecutive
+}
+
+// failed lookups are retried sooner, but backing off so an outage at the registry (or an
+// unsupported TLD) doesn't have us asking every minute
+func (d DomainExpiry) NextLookup() time.Time {
+	if d.FailedLookups == 0 {
+		return d.LookedUp.Add(DomainExpiryLookupInterval)
+	}
+
+	interval := DomainExpiryFailedLookupInterval
+	for i := 1; i < d.FailedLookups && interval < DomainExpiryLookupInterval; i++ {
+		interval *= 2
+	}
+
+	if interval > DomainExpiryLookupInterval {
+		interval = DomainExpiryLookupInterval
+	}
+
+	return d.LookedUp.Add(interval)
+}
+
+type LocationResult struct {
+	Location string    `json:"location"`
+	Ok       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Checked  time.Time `json:"checked"`
+}
+
+const (
+	DefaultMonitorInterval = 1 * time.Minute
+	DefaultMonitorTimeout  = 30 * time.Second
+	// registries don't like to be queried often, and expiry dates don't change often
+	DomainExpiryLookupInterval = 24 * time.Hour
+	// doubles for each consecutive failure, up to DomainExpiryLookupInterval
+	DomainExpiryFailedLookupInterval = 15 * time.Minute
+	DefaultExpiryAlertDays           = 30
+)
+
+// alerts of the same monitor have the same subject, so they get deduplicated. HTTP monitors
+// use plain URL for backwards compatibility
+func (h Monitor) Subject() string {
+	switch h.Kind {
+	case amdomain.MonitorKindHttp:
+		return h.Target
+	case amdomain.MonitorKindDns:
+		return fmt.Sprintf("%s %s %s", h.Kind, h.Target, h.DnsRecordType)
+	default:
+		return fmt.Sprintf("%s %s", h.Kind, h.Target)
+	}
+}
+
+// how often the monitor should be checked
+func (h Monitor) GetInterval() time.Duration {
+	if h.Interval == 0 {
+		return DefaultMonitorInterval
+	}
+
+	return h.Interval
+}
+
+// how long one check (including retry) can take
+func (h Monitor) GetTimeout() time.Duration {
+	if h.Timeout == 0 {
+		return DefaultMonitorTimeout
+	}
+
+	return h.Timeout
+}
+
+// used if https_audit monitor doesn't specify one
+func DefaultHttpsAuditPolicy() amdomain.HttpsAuditPolicy {
+	return amdomain.HttpsAuditPolicy{
+		RequireHeaders:  []string{"Strict-Transport-Security"},
+		RedirectToHttps: true,
+		MinTlsVersion:   "1.2",
+		CertHostname:    true,
+	}
+}
+
+func (h Monitor) GetAuditPolicy() amdomain.HttpsAuditPolicy {
+	if h.Audit == nil {
+		return DefaultHttpsAuditPolicy()
+	}
+
+	return *h.Audit
+}
+
+// alert when domain expires in less than this many days
+func (h Monitor) GetExpiryAlertDays() int {
+	if h.ExpiryAlertDays == 0 {
+		return DefaultExpiryAlertDays
+	}
+
+	return h.ExpiryAlertDays
+}
+
+// nil if not looked up recently enough. zero if the lookup failed (and it's not yet time to retry)
+func (h Monitor) CachedDomainExpiry(now time.Time) *time.Time {
+	if h.DomainExpiry == nil || !now.Before(h.DomainExpiry.NextLookup()) {
+		return nil
+	}
+
+	return &h.DomainExpiry.Expires
+}
+
+// how many consecutive runs have to fail before we alert
+func (h Monitor) GetAlertAfterFailures() int {
+	if h.AlertAfterFailures == 0 {
+		return 1
+	}
+
+	return h.AlertAfterFailures
+}
+
+// how many locations have to see the monitor failing before we alert
+func (h Monitor) GetQuorum() int {
+	if h.Quorum == 0 {
+		return 1
+	}
+
+	return h.Quorum
+}
+
+// failures older than this don't count towards quorum. default gives each location time to
+// check the monitor twice
+func (h Monitor) GetQuorumWindow() time.Duration {
+	if h.QuorumWindow == 0 {
+		return 2 * h.GetInterval()
+	}
+
+	return h.QuorumWindow
+}
+
+// when the monitor was last checked from the given location (zero if never)
+func (h Monitor) LastCheckedFrom(location string) time.Time {
+	// results written before we knew locations were all from the scheduler
+	if len(h.LatestByLocation) == 0 {
+		return h.LastChecked
+	}
+
+	return h.LatestByLocation[location].Checked
+}
+
+// locations whose latest result is a failure within the quorum window, ordered by location
+func (h Monitor) FailingLocations(now time.Time) []LocationResult {
+	return FailingLocations(h.LatestByLocation, h.GetQuorumWindow(), now)
+}
+
+// monitor is failing once enough locations agree on it
+func (h Monitor) QuorumFailing(now time.Time) bool {
+	return len(h.FailingLocations(now)) >= h.GetQuorum()
 }
 
 type DeadMansSwitch struct {
 	Subject string    `json:"subject"`
 	Ttl     time.Time `json:"ttl"`
+	// if set, deadline after a check-in is next scheduled time + grace
+	Schedule string        `json:"schedule,omitempty"`
+	Grace    time.Duration `json:"grace,omitempty"`
+	// most recent last. replaced (not mutated) on update, since copies of the switch share it
+	History []DeadMansSwitchCheckin `json:"history,omitempty"`
+	Run     *DeadMansSwitchRun      `json:"run,omitempty"` // nil if job is not running
+	Paused  bool                    `json:"paused,omitempty"`
+	// auto-resume time of a paused switch. nil = paused until resumed
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	Runbook     string     `json:"runbook,omitempty"` // URL
+}
+
+// auto-resume happens by time passing, so there's no event for it
+func (d DeadMansSwitch) IsPaused(now time.Time) bool {
+	return d.Paused && (d.PausedUntil == nil || now.Before(*d.PausedUntil))
+}
+
+type DeadMansSwitchCheckin struct {
+	Time     time.Time          `json:"time"`
+	Message  string             `json:"message,omitempty"`
+	Fields   map[string]float64 `json:"fields,omitempty"`
+	Duration time.Duration      `json:"duration,omitempty"` // of the run this check-in completed
+	Failed   bool               `json:"failed,omitempty"`   // job reported failure (Message has the error)
+}
+
+type DeadMansSwitchRun struct {
+	Started    time.Time     `json:"started"`
+	MaxRuntime time.Duration `json:"max_runtime"`
+}
+
+// job is hung (or crashed without telling us) if it hasn't checked in by this
+func (d DeadMansSwitchRun) Deadline() time.Time {
+	return d.Started.Add(d.MaxRuntime)
+}
+
+// how many check-ins we keep per switch
+const DeadMansSwitchHistoryLength = 10
+
+type Agent struct {
+	Name         string    `json:"name"`
+	TokenHash    string    `json:"token_hash"`
+	Created      time.Time `json:"created"`
+	LastReported time.Time `json:"last_reported"` // zero if never reported
+}
+
+// agent is considered silent (and its monitors not being checked) after this
+const AgentSilenceThreshold = 5 * time.Minute
+
+// alerts for the agent itself (as opposed to its monitors) have this subject
+func (a Agent) Subject() string {
+	return "agent " + a.Name
+}
+
+// last time we heard from the agent (or knew of it, if it never reported)
+func (a Agent) LastSeen() time.Time {
+	if a.LastReported.IsZero() {
+		return a.Created
+	}
+
+	return a.LastReported
 }
@@ -1,9 +1,11 @@
 package amstate
 
 import (
+	"sort"
 	"time"
 
 	"github.com/function61/gokit/cryptorandombytes"
+	"github.com/function61/gokit/sliceutil"
 )
 
 func FindAlertWithSubject(subject string, alerts []Alert) *Alert {
@@ -38,7 +40,7 @@ func GetUnnoticedAlerts(alerts []Alert, now time.Time) []Alert {
 	return unnoticed
 }
 
-func FindHttpMonitorWithId(id string, monitors []HttpMonitor) *HttpMonitor {
+func FindMonitorWithId(id string, monitors []Monitor) *Monitor {
 	for _, monitor := range monitors {
 		if monitor.Id == id {
 			return &monitor
@@ -48,8 +50,18 @@ func FindHttpMonitorWithId(id string, monitors []HttpMonitor) *HttpMonitor {
 	return nil
 }
 
-func EnabledHttpMonitors(monitors []HttpMonitor) []HttpMonitor {
-	enabled := []HttpMonitor{}
+func FindMonitorWithSubject(subject string, monitors []Monitor) *Monitor {
+	for _, monitor := range monitors {
+		if monitor.Subject() == subject {
+			return &monitor
+		}
+	}
+
+	return nil
+}
+
+func EnabledMonitors(monitors []Monitor) []Monitor {
+	enabled := []Monitor{}
 
 	for _, monitor := range monitors {
 		if monitor.Enabled {
@@ -60,6 +72,75 @@ func EnabledHttpMonitors(monitors []HttpMonitor) []HttpMonitor {
 	return enabled
 }
 
+// returns monitors whose interval has elapsed since last check from the location. comparison is done at minute
+// granularity so that jitter in scheduler invocations doesn't make us skip a run.
+func DueMonitors(monitors []Monitor, location string, now time.Time) []Monitor {
+	due := []Monitor{}
+
+	for _, monitor := range monitors {
+		lastChecked := monitor.LastCheckedFrom(location)
+
+		sinceLastCheck := now.Truncate(time.Minute).Sub(lastChecked.Truncate(time.Minute))
+
+		if sinceLastCheck >= monitor.GetInterval() {
+			due = append(due, monitor)
+		}
+	}
+
+	return due
+}
+
+// monitors that are checked by the given agent ("" = by the scheduler)
+func MonitorsCheckedBy(agent string, monitors []Monitor) []Monitor {
+	checkedBy := []Monitor{}
+
+	for _, monitor := range monitors {
+		if (agent == "" && len(monitor.Agents) == 0) || (agent != "" && sliceutil.ContainsString(monitor.Agents, agent)) {
+			checkedBy = append(checkedBy, monitor)
+		}
+	}
+
+	return checkedBy
+}
+
+// latest failures (within window) by location. separate from Monitor so the quorum logic can
+// be tested with synthetic results
+func FailingLocations(latestByLocation map[string]LocationResult, window time.Duration, now time.Time) []LocationResult {
+	failing := []LocationResult{}
+
+	for _, result := range latestByLocation {
+		if !result.Ok && now.Sub(result.Checked) <= window {
+			failing = append(failing, result)
+		}
+	}
+
+	sort.Slice(failing, func(i, j int) bool { return failing[i].Location < failing[j].Location })
+
+	return failing
+}
+
+func FindAgentWithName(name string, agents []Agent) *Agent {
+	for _, agent := range agents {
+		if agent.Name == name {
+			return &agent
+		}
+	}
+
+	return nil
+}
+
+// agents that haven't reported within AgentSilenceThreshold
+func GetSilentAgents(agents []Agent, now time.Time) []Agent {
+	silent := []Agent{}
+	for _, agent := range agents {
+		if now.Sub(agent.LastSeen()) >= AgentSilenceThreshold {
+			silent = append(silent, agent)
+		}
+	}
+
+	return silent
+}
+
 func FindDeadMansSwitchWithSubject(subject string, dmss []DeadMansSwitch) *DeadMansSwitch {
 	for _, dms := range dmss {
 		if dms.Subject == subject {
@@ -70,10 +151,11 @@ func FindDeadMansSwitchWithSubject(subject string, dmss []DeadMansSwitch) *DeadM
 	return nil
 }
 
+// paused switches are not expired
 func GetExpiredDeadMansSwitches(switches []DeadMansSwitch, now time.Time) []DeadMansSwitch {
 	expired := []DeadMansSwitch{}
 	for _, sw := range switches {
-		if !now.Before(sw.Ttl) {
+		if !now.Before(sw.Ttl) && !sw.IsPaused(now) {
 			expired = append(expired, sw)
 		}
 	}
@@ -81,10 +163,22 @@ func GetExpiredDeadMansSwitches(switches []DeadMansSwitch, now time.Time) []Dead
 	return expired
 }
 
+// switches whose job started but didn't check in within its max runtime
+func GetOverdueDeadMansSwitchRuns(switches []DeadMansSwitch, now time.Time) []DeadMansSwitch {
+	overdue := []DeadMansSwitch{}
+	for _, sw := range switches {
+		if sw.Run != nil && !now.Before(sw.Run.Deadline()) && !sw.IsPaused(now) {
+			overdue = append(overdue, sw)
+		}
+	}
+
+	return overdue
+}
+
 func NewAlertId() string {
 	return cryptorandombytes.Base64UrlWithoutLeadingDash(6)
 }
 
-func NewHttpMonitorId() string {
+func NewMonitorId() string {
 	return cryptorandombytes.Base64UrlWithoutLeadingDash(6)
 }